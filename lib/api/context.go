@@ -0,0 +1,15 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// ContextWithTimeout returns a context without a deadline if the timeout is not positive
+func ContextWithTimeout(timeoutMs int64) (context.Context, context.CancelFunc) {
+	if timeoutMs <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), time.Duration(timeoutMs)*time.Millisecond)
+}
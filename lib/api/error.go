@@ -0,0 +1,35 @@
+package api
+
+import "lib/matrix"
+
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidRequest       ErrorCode = "invalid_request"
+	ErrorCodeUnsupportedVersion   ErrorCode = "unsupported_version"
+	ErrorCodeUnsupportedOperation ErrorCode = "unsupported_operation"
+	ErrorCodeSessionFailed        ErrorCode = "session_failed"
+	ErrorCodeOperationFailed      ErrorCode = "operation_failed"
+)
+
+// NewError uses the error category from the matrix package as the code and falls back to the given code
+// for errors that could not be classified
+func NewError(code ErrorCode, err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	classified := matrix.Classify(err)
+	if classified.Category != matrix.ErrorCategoryUnknown {
+		code = ErrorCode(classified.Category)
+	}
+
+	return &Error{
+		Code:         code,
+		Message:      err.Error(),
+		ErrCode:      classified.ErrCode,
+		HttpStatus:   classified.HttpStatus,
+		RetryAfterMs: classified.RetryAfter.Milliseconds(),
+		Retryable:    classified.Retryable(),
+	}
+}
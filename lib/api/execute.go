@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lib/matrix"
+	"lib/types"
+	"time"
+)
+
+func Execute(requestJson []byte, clientFactory matrix.MautrixFactory) []byte {
+	response := execute(requestJson, clientFactory)
+	response.Version = Version
+
+	serialized, err := json.Marshal(response)
+	if err != nil {
+		serialized, _ = json.Marshal(Response{
+			Version: Version,
+			Error:   NewError(ErrorCodeOperationFailed, err),
+		})
+	}
+
+	return serialized
+}
+
+func execute(requestJson []byte, clientFactory matrix.MautrixFactory) Response {
+	var request Request
+	if err := json.Unmarshal(requestJson, &request); err != nil {
+		return Response{Error: NewError(ErrorCodeInvalidRequest, err)}
+	}
+
+	if request.Version != Version {
+		return Response{Error: NewError(
+			ErrorCodeUnsupportedVersion,
+			fmt.Errorf("unsupported request version %d, supported version is %d", request.Version, Version),
+		)}
+	}
+
+	if request.Content.MessageType == "" {
+		request.Content.MessageType = types.MessageTypeTextMessage
+	}
+	if request.Content.RenderingType == "" {
+		request.Content.RenderingType = types.RenderingTypePlainText
+	}
+
+	ctx, cancel := ContextWithTimeout(request.TimeoutMs)
+	defer cancel()
+
+	if request.Operation == OperationLogin {
+		return login(ctx, request, clientFactory)
+	}
+
+	if err := validate(request); err != nil {
+		return Response{Error: err}
+	}
+
+	credentials := request.Credentials
+	session, err := matrix.OpenSession(
+		ctx,
+		credentials.DatabaseDsn,
+		credentials.AccessToken,
+		credentials.RecoveryKey,
+		[]byte(credentials.PickleKey),
+		credentials.Url,
+		credentials.DeviceId,
+		retryPolicy(request.Options.Retry),
+		invitePolicy(request.Options.Invites),
+		matrix.DeviceTrustMode(request.Options.DeviceTrust),
+		false,
+		clientFactory,
+	)
+	if err != nil {
+		return Response{Error: NewError(ErrorCodeSessionFailed, err)}
+	}
+	defer session.Close()
+
+	var response Response
+	if request.Operation == OperationSend {
+		response = send(ctx, session, request)
+	} else {
+		response = Response{Results: []Result{inRoom(ctx, session, request)}}
+	}
+	response.Invites = inviteDecisions(session.InviteDecisions())
+
+	return response
+}
+
+func validate(request Request) *Error {
+	switch request.Operation {
+	case OperationSend:
+		if len(request.Recipients) == 0 {
+			return NewError(ErrorCodeInvalidRequest, matrix.ErrNoRecipients)
+		}
+	case OperationEdit, OperationRedact, OperationReact, OperationUnreact:
+		if len(request.Recipients) != 1 {
+			return NewError(
+				ErrorCodeInvalidRequest,
+				fmt.Errorf("the %s operation requires exactly one recipient", request.Operation),
+			)
+		}
+		if request.Content.EventId == "" {
+			return NewError(
+				ErrorCodeInvalidRequest,
+				fmt.Errorf("the %s operation requires an event ID", request.Operation),
+			)
+		}
+	default:
+		return NewError(ErrorCodeUnsupportedOperation, fmt.Errorf("unsupported operation: %s", request.Operation))
+	}
+
+	return nil
+}
+
+func retryPolicy(retry *Retry) *matrix.RetryPolicy {
+	policy := matrix.DefaultRetryPolicy
+	if retry == nil {
+		return &policy
+	}
+
+	if retry.MaxAttempts > 0 {
+		policy.MaxAttempts = retry.MaxAttempts
+	}
+	if retry.InitialBackoffMs > 0 {
+		policy.InitialBackoff = time.Duration(retry.InitialBackoffMs) * time.Millisecond
+	}
+	if retry.MaxBackoffMs > 0 {
+		policy.MaxBackoff = time.Duration(retry.MaxBackoffMs) * time.Millisecond
+	}
+	if retry.DeadlineMs > 0 {
+		policy.Deadline = time.Duration(retry.DeadlineMs) * time.Millisecond
+	}
+
+	return &policy
+}
+
+func NewResult(recipient string, result matrix.RecipientResult) Result {
+	converted := Result{
+		Recipient: recipient,
+		RoomId:    result.RoomId,
+		EventId:   result.EventId,
+		Attempts:  result.Attempts,
+		Error:     NewError(ErrorCodeOperationFailed, result.Err),
+	}
+	for _, device := range result.Withheld {
+		converted.Withheld = append(converted.Withheld, WithheldDevice{
+			UserId:   device.UserId,
+			DeviceId: device.DeviceId,
+			Trust:    device.Trust.String(),
+			Reason:   device.Reason,
+		})
+	}
+
+	return converted
+}
+
+func invitePolicy(invites *InvitePolicy) *matrix.InvitePolicy {
+	if invites == nil {
+		return nil
+	}
+
+	return &matrix.InvitePolicy{
+		UserIds:      invites.UserIds,
+		Servers:      invites.Servers,
+		RoomPatterns: invites.RoomPatterns,
+	}
+}
+
+func inviteDecisions(decisions []matrix.InviteDecision) []InviteDecision {
+	var result []InviteDecision
+	for _, decision := range decisions {
+		result = append(result, InviteDecision{
+			RoomId:   decision.RoomId,
+			Inviter:  decision.Inviter,
+			Accepted: decision.Accepted,
+			Reason:   decision.Reason,
+			Error:    NewError(ErrorCodeOperationFailed, decision.Err),
+		})
+	}
+
+	return result
+}
+
+func login(ctx context.Context, request Request, clientFactory matrix.MautrixFactory) Response {
+	deviceId, accessToken, err := matrix.Login(
+		ctx,
+		request.Credentials.Url,
+		request.Credentials.Username,
+		request.Credentials.Password,
+		clientFactory,
+	)
+	if err != nil {
+		return Response{Error: NewError(ErrorCodeOperationFailed, err)}
+	}
+
+	return Response{Login: &LoginResult{DeviceId: deviceId, AccessToken: accessToken}}
+}
+
+func send(ctx context.Context, session *matrix.Session, request Request) Response {
+	content := request.Content
+	options := &matrix.MessageOptions{
+		ThreadRootEventId: request.Options.ThreadRootEventId,
+		ReplyToEventId:    request.Options.ReplyToEventId,
+		MentionUserIds:    request.Options.MentionUserIds,
+		MentionRoom:       request.Options.MentionRoom,
+		IdempotencyKey:    request.Options.IdempotencyKey,
+	}
+
+	var results map[string]matrix.RecipientResult
+	var err error
+	if content.Attachment != nil {
+		results, err = session.SendAttachmentToRecipients(
+			ctx,
+			content.MessageType,
+			&matrix.Attachment{
+				FileName: content.Attachment.FileName,
+				MimeType: content.Attachment.MimeType,
+				Data:     content.Attachment.Data,
+			},
+			request.Recipients,
+			options,
+		)
+	} else {
+		results, err = session.SendMessageToRecipients(
+			ctx,
+			content.MessageType,
+			content.RenderingType,
+			content.Message,
+			request.Recipients,
+			options,
+		)
+	}
+	if err != nil {
+		return Response{Error: NewError(ErrorCodeOperationFailed, err)}
+	}
+
+	response := Response{}
+	for _, recipient := range request.Recipients {
+		result, ok := results[recipient]
+		if !ok {
+			continue
+		}
+		delete(results, recipient)
+
+		response.Results = append(response.Results, NewResult(recipient, result))
+	}
+
+	return response
+}
+
+func inRoom(ctx context.Context, session *matrix.Session, request Request) Result {
+	recipient := request.Recipients[0]
+	content := request.Content
+
+	var eventId string
+	var err error
+	switch request.Operation {
+	case OperationEdit:
+		eventId, err = session.EditMessage(
+			ctx,
+			content.MessageType,
+			content.RenderingType,
+			content.Message,
+			recipient,
+			content.EventId,
+		)
+	case OperationRedact:
+		eventId, err = session.RedactMessage(ctx, recipient, content.EventId, content.Reason)
+	case OperationReact:
+		eventId, err = session.React(ctx, recipient, content.EventId, content.Key)
+	case OperationUnreact:
+		eventId, err = session.Unreact(ctx, recipient, content.EventId, content.Key)
+	}
+
+	result := Result{
+		Recipient: recipient,
+		EventId:   eventId,
+		Error:     NewError(ErrorCodeOperationFailed, err),
+	}
+	if err == nil {
+		result.RoomId, _ = session.ResolveRoom(ctx, recipient)
+	}
+
+	return result
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"lib/matrix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+func executeJson(t *testing.T, request string, clientFactory func() (*mautrix.Client, error)) Response {
+	t.Helper()
+
+	var response Response
+	if err := json.Unmarshal(Execute([]byte(request), clientFactory), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if response.Version != Version {
+		t.Fatalf("expected response version %d, got %d", Version, response.Version)
+	}
+
+	return response
+}
+
+func TestExecuteErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  string
+		expected ErrorCode
+	}{
+		{"invalid json", `{`, ErrorCodeInvalidRequest},
+		{"unsupported version", `{"version": 2, "operation": "send"}`, ErrorCodeUnsupportedVersion},
+		{"unsupported operation", `{"version": 1, "operation": "delete"}`, ErrorCodeUnsupportedOperation},
+		{"missing recipients", `{"version": 1, "operation": "send"}`, ErrorCodeInvalidRequest},
+		{"multiple recipients", `{"version": 1, "operation": "edit", "recipients": ["!a:b", "!c:d"], "content": {"event_id": "$e"}}`, ErrorCodeInvalidRequest},
+		{"missing event id", `{"version": 1, "operation": "react", "recipients": ["!a:b"]}`, ErrorCodeInvalidRequest},
+		{"invalid dsn", `{"version": 1, "operation": "send", "recipients": ["!a:b"], "credentials": {"database_dsn": "invalid"}}`, ErrorCode(matrix.ErrorCategoryInvalidConfig)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := executeJson(t, test.request, nil)
+			if response.Error == nil || response.Error.Code != test.expected {
+				t.Fatalf("expected error code %s, got %+v", test.expected, response.Error)
+			}
+			if response.Error.Message == "" {
+				t.Fatalf("expected error message")
+			}
+		})
+	}
+}
+
+func TestExecuteLogin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "token",
+			"device_id":    "DEVICE",
+			"user_id":      "@bot:example.com",
+		})
+	}))
+	defer server.Close()
+
+	response := executeJson(t, `{"version": 1, "operation": "login", "credentials": {"username": "bot", "password": "secret"}}`, func() (*mautrix.Client, error) {
+		return mautrix.NewClient(server.URL, "", "")
+	})
+
+	if response.Error != nil {
+		t.Fatalf("expected no error, got %+v", response.Error)
+	}
+	if response.Login == nil || response.Login.DeviceId != "DEVICE" || response.Login.AccessToken != "token" {
+		t.Fatalf("expected login result, got %+v", response.Login)
+	}
+}
+
+func TestInvitePolicy(t *testing.T) {
+	if invitePolicy(nil) != nil {
+		t.Fatalf("expected no policy when invites are not configured")
+	}
+
+	var request Request
+	if err := json.Unmarshal([]byte(`{"options": {"invites": {"user_ids": ["@admin:example.org"], "servers": ["example.org"], "room_patterns": ["!*:example.org"]}}}`), &request); err != nil {
+		t.Fatalf("failed to unmarshal request: %v", err)
+	}
+
+	policy := invitePolicy(request.Options.Invites)
+	if len(policy.UserIds) != 1 || policy.UserIds[0] != "@admin:example.org" {
+		t.Fatalf("expected user @admin:example.org, got %v", policy.UserIds)
+	}
+	if len(policy.Servers) != 1 || len(policy.RoomPatterns) != 1 {
+		t.Fatalf("expected a server and a room pattern, got %+v", policy)
+	}
+}
+
+func TestInviteDecisions(t *testing.T) {
+	decisions := inviteDecisions([]matrix.InviteDecision{
+		{RoomId: "!room:example.org", Inviter: "@admin:example.org", Accepted: true, Reason: matrix.InviteReasonAllowedUser},
+		{RoomId: "!spam:example.org", Inviter: "@spammer:example.org", Reason: matrix.InviteReasonNotAllowed, Err: errors.New("failed")},
+	})
+
+	if len(decisions) != 2 {
+		t.Fatalf("expected 2 decisions, got %d", len(decisions))
+	}
+	if !decisions[0].Accepted || decisions[0].Error != nil {
+		t.Fatalf("expected an accepted invite without error, got %+v", decisions[0])
+	}
+	if decisions[1].Accepted || decisions[1].Error == nil || decisions[1].Reason != matrix.InviteReasonNotAllowed {
+		t.Fatalf("expected a rejected invite with an error, got %+v", decisions[1])
+	}
+}
+
+func TestNewResultWithheld(t *testing.T) {
+	result := NewResult("@alice:example.org", matrix.RecipientResult{
+		RoomId:  "!room:example.org",
+		EventId: "$event",
+		Withheld: []matrix.WithheldDevice{
+			{UserId: "@bob:example.org", DeviceId: "BOB", Trust: id.TrustStateCrossSignedUntrusted, Reason: matrix.WithheldReasonKeyChanged},
+		},
+	})
+
+	if result.Error != nil || result.EventId != "$event" {
+		t.Fatalf("expected a delivered result, got %+v", result)
+	}
+	if len(result.Withheld) != 1 || result.Withheld[0].Reason != matrix.WithheldReasonKeyChanged || result.Withheld[0].Trust != "cross-signed-untrusted" {
+		t.Fatalf("expected a device withheld because of a key change, got %+v", result.Withheld)
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"lib/types"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const Version = 1
+
+type Operation string
+
+const (
+	OperationLogin   Operation = "login"
+	OperationSend    Operation = "send"
+	OperationEdit    Operation = "edit"
+	OperationRedact  Operation = "redact"
+	OperationReact   Operation = "react"
+	OperationUnreact Operation = "unreact"
+)
+
+type Request struct {
+	Version     int         `json:"version"`
+	Operation   Operation   `json:"operation"`
+	TimeoutMs   int64       `json:"timeout_ms"`
+	Credentials Credentials `json:"credentials"`
+	Recipients  []string    `json:"recipients"`
+	Content     Content     `json:"content"`
+	Options     Options     `json:"options"`
+}
+
+type Credentials struct {
+	DatabaseDsn string      `json:"database_dsn"`
+	AccessToken string      `json:"access_token"`
+	RecoveryKey string      `json:"recovery_key"`
+	PickleKey   string      `json:"pickle_key"`
+	Url         string      `json:"url"`
+	DeviceId    id.DeviceID `json:"device_id"`
+	Username    string      `json:"username"`
+	Password    string      `json:"password"`
+}
+
+type Content struct {
+	MessageType   types.MessageType   `json:"message_type"`
+	RenderingType types.RenderingType `json:"rendering_type"`
+	Message       string              `json:"message"`
+	Attachment    *Attachment         `json:"attachment"`
+	EventId       id.EventID          `json:"event_id"`
+	Reason        string              `json:"reason"`
+	Key           string              `json:"key"`
+}
+
+type Attachment struct {
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+type Options struct {
+	ThreadRootEventId id.EventID    `json:"thread_root_event_id"`
+	ReplyToEventId    id.EventID    `json:"reply_to_event_id"`
+	MentionUserIds    []id.UserID   `json:"mention_user_ids"`
+	MentionRoom       bool          `json:"mention_room"`
+	IdempotencyKey    string        `json:"idempotency_key"`
+	Retry             *Retry        `json:"retry"`
+	Invites           *InvitePolicy `json:"invites"`
+	DeviceTrust       string        `json:"device_trust"`
+}
+
+type Retry struct {
+	MaxAttempts      int   `json:"max_attempts"`
+	InitialBackoffMs int64 `json:"initial_backoff_ms"`
+	MaxBackoffMs     int64 `json:"max_backoff_ms"`
+	DeadlineMs       int64 `json:"deadline_ms"`
+}
+
+type InvitePolicy struct {
+	UserIds      []id.UserID `json:"user_ids"`
+	Servers      []string    `json:"servers"`
+	RoomPatterns []string    `json:"room_patterns"`
+}
+
+type Response struct {
+	Version int              `json:"version"`
+	Results []Result         `json:"results,omitempty"`
+	Login   *LoginResult     `json:"login,omitempty"`
+	Invites []InviteDecision `json:"invites,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+type Result struct {
+	Recipient string           `json:"recipient"`
+	RoomId    id.RoomID        `json:"room_id,omitempty"`
+	EventId   string           `json:"event_id,omitempty"`
+	Attempts  int              `json:"attempts,omitempty"`
+	Withheld  []WithheldDevice `json:"withheld,omitempty"`
+	Error     *Error           `json:"error,omitempty"`
+}
+
+type WithheldDevice struct {
+	UserId   id.UserID   `json:"user_id"`
+	DeviceId id.DeviceID `json:"device_id"`
+	Trust    string      `json:"trust"`
+	Reason   string      `json:"reason"`
+}
+
+type InviteDecision struct {
+	RoomId   id.RoomID `json:"room_id"`
+	Inviter  id.UserID `json:"inviter"`
+	Accepted bool      `json:"accepted"`
+	Reason   string    `json:"reason"`
+	Error    *Error    `json:"error,omitempty"`
+}
+
+type LoginResult struct {
+	DeviceId    id.DeviceID `json:"device_id"`
+	AccessToken string      `json:"access_token"`
+}
+
+type Error struct {
+	Code         ErrorCode `json:"code"`
+	Message      string    `json:"message"`
+	ErrCode      string    `json:"errcode,omitempty"`
+	HttpStatus   int       `json:"http_status,omitempty"`
+	RetryAfterMs int64     `json:"retry_after_ms,omitempty"`
+	Retryable    bool      `json:"retryable"`
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"lib/api"
+	"lib/matrix"
+	"lib/types"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+type whoamiOutput struct {
+	UserId   id.UserID   `json:"user_id"`
+	DeviceId id.DeviceID `json:"device_id,omitempty"`
+}
+
+type sendOutput struct {
+	Results []api.Result `json:"results"`
+}
+
+func (receiver sendOutput) failed() bool {
+	return slices.ContainsFunc(receiver.Results, func(result api.Result) bool {
+		return result.Error != nil
+	})
+}
+
+type verifyDeviceOutput struct {
+	DeviceId id.DeviceID `json:"device_id"`
+	Verified bool        `json:"verified"`
+}
+
+func login(ctx context.Context, args []string, _ io.Reader) (any, error) {
+	var cfg config
+	var username, password string
+
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	cfg.register(flags)
+	flags.StringVar(&username, "username", env("USERNAME", ""), "the username (env "+envPrefix+"USERNAME)")
+	flags.StringVar(&password, "password", env("PASSWORD", ""), "the password (env "+envPrefix+"PASSWORD)")
+	if err := parse(flags, args, "url", "username", "password"); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := cfg.context(ctx)
+	defer cancel()
+
+	deviceId, accessToken, err := matrix.Login(ctx, cfg.url, username, password, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.LoginResult{DeviceId: deviceId, AccessToken: accessToken}, nil
+}
+
+func whoami(ctx context.Context, args []string, _ io.Reader) (any, error) {
+	var cfg config
+
+	flags := flag.NewFlagSet("whoami", flag.ContinueOnError)
+	cfg.register(flags)
+	cfg.registerAccessToken(flags)
+	if err := parse(flags, args, "url", "access-token"); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := cfg.context(ctx)
+	defer cancel()
+
+	userId, deviceId, err := matrix.Whoami(ctx, cfg.url, cfg.accessToken, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return whoamiOutput{UserId: userId, DeviceId: deviceId}, nil
+}
+
+func send(ctx context.Context, args []string, stdin io.Reader) (any, error) {
+	var cfg config
+	var recipients, messageType, renderingType, message, idempotencyKey string
+
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	cfg.register(flags)
+	cfg.registerDevice(flags)
+	flags.StringVar(&recipients, "recipients", env("RECIPIENTS", ""), "comma separated room IDs, room aliases or user IDs (env "+envPrefix+"RECIPIENTS)")
+	flags.StringVar(&messageType, "message-type", string(types.MessageTypeTextMessage), "the message type")
+	flags.StringVar(&renderingType, "rendering-type", string(types.RenderingTypePlainText), "the rendering type, one of text, html or markdown")
+	flags.StringVar(&message, "message", "", "the message body, read from the standard input if empty")
+	flags.StringVar(&idempotencyKey, "idempotency-key", "", "a unique key of the message which prevents sending it twice")
+	if err := parse(flags, args, "url", "access-token", "database-dsn", "recovery-key", "pickle-key", "device-id", "recipients"); err != nil {
+		return nil, err
+	}
+
+	if message == "" {
+		body, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		message = strings.TrimSuffix(string(body), "\n")
+	}
+
+	ctx, cancel := cfg.context(ctx)
+	defer cancel()
+
+	recipientList := matrix.SplitList(recipients)
+	results, err := matrix.SendMessage(
+		ctx,
+		types.MessageType(messageType),
+		types.RenderingType(renderingType),
+		message,
+		recipientList,
+		cfg.databaseDsn,
+		cfg.accessToken,
+		cfg.recoveryKey,
+		[]byte(cfg.pickleKey),
+		cfg.url,
+		id.DeviceID(cfg.deviceId),
+		&matrix.MessageOptions{IdempotencyKey: idempotencyKey},
+		nil,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	output := sendOutput{Results: []api.Result{}}
+	for _, recipient := range recipientList {
+		result, ok := results[recipient]
+		if !ok {
+			continue
+		}
+		delete(results, recipient)
+
+		output.Results = append(output.Results, api.NewResult(recipient, result))
+	}
+
+	return output, nil
+}
+
+func verifyDevice(ctx context.Context, args []string, _ io.Reader) (any, error) {
+	var cfg config
+
+	flags := flag.NewFlagSet("verify-device", flag.ContinueOnError)
+	cfg.register(flags)
+	cfg.registerDevice(flags)
+	if err := parse(flags, args, "url", "access-token", "database-dsn", "recovery-key", "pickle-key", "device-id"); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := cfg.context(ctx)
+	defer cancel()
+
+	err := matrix.VerifyDevice(
+		ctx,
+		cfg.databaseDsn,
+		cfg.accessToken,
+		cfg.recoveryKey,
+		[]byte(cfg.pickleKey),
+		cfg.url,
+		id.DeviceID(cfg.deviceId),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyDeviceOutput{DeviceId: id.DeviceID(cfg.deviceId), Verified: true}, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"lib/api"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envPrefix = "MATRIX_NOTIFIER_"
+
+type errorOutput struct {
+	Error *api.Error `json:"error"`
+}
+
+type invalidRequestError struct {
+	err error
+}
+
+func (receiver invalidRequestError) Error() string {
+	return receiver.err.Error()
+}
+
+func (receiver invalidRequestError) Unwrap() error {
+	return receiver.err
+}
+
+func newError(err error) *api.Error {
+	var invalidRequest invalidRequestError
+	if errors.As(err, &invalidRequest) {
+		return api.NewError(api.ErrorCodeInvalidRequest, err)
+	}
+
+	return api.NewError(api.ErrorCodeOperationFailed, err)
+}
+
+type config struct {
+	url         string
+	accessToken string
+	databaseDsn string
+	recoveryKey string
+	pickleKey   string
+	deviceId    string
+	timeoutMs   int64
+}
+
+func (receiver *config) register(flags *flag.FlagSet) {
+	flags.StringVar(&receiver.url, "url", env("URL", ""), "the homeserver URL (env "+envPrefix+"URL)")
+	flags.Int64Var(&receiver.timeoutMs, "timeout", envInt("TIMEOUT", 60_000), "the timeout in milliseconds, 0 disables it (env "+envPrefix+"TIMEOUT)")
+}
+
+func (receiver *config) registerAccessToken(flags *flag.FlagSet) {
+	flags.StringVar(&receiver.accessToken, "access-token", env("ACCESS_TOKEN", ""), "the access token (env "+envPrefix+"ACCESS_TOKEN)")
+}
+
+func (receiver *config) registerDevice(flags *flag.FlagSet) {
+	receiver.registerAccessToken(flags)
+	flags.StringVar(&receiver.databaseDsn, "database-dsn", env("DATABASE_DSN", ""), "the DSN of the internal database (env "+envPrefix+"DATABASE_DSN)")
+	flags.StringVar(&receiver.recoveryKey, "recovery-key", env("RECOVERY_KEY", ""), "the recovery key (env "+envPrefix+"RECOVERY_KEY)")
+	flags.StringVar(&receiver.pickleKey, "pickle-key", env("PICKLE_KEY", ""), "the pickle key (env "+envPrefix+"PICKLE_KEY)")
+	flags.StringVar(&receiver.deviceId, "device-id", env("DEVICE_ID", ""), "the device ID (env "+envPrefix+"DEVICE_ID)")
+}
+
+func (receiver *config) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if receiver.timeoutMs <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, time.Duration(receiver.timeoutMs)*time.Millisecond)
+}
+
+func parse(flags *flag.FlagSet, args []string, required ...string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return invalidRequestError{err: err}
+	}
+
+	for _, name := range required {
+		if flags.Lookup(name).Value.String() == "" {
+			return invalidRequestError{err: fmt.Errorf("the -%s flag or the %s%s environment variable is required", name, envPrefix, envName(name))}
+		}
+	}
+
+	return nil
+}
+
+func env(name string, fallback string) string {
+	if value, ok := os.LookupEnv(envPrefix + name); ok {
+		return value
+	}
+
+	return fallback
+}
+
+func envInt(name string, fallback int64) int64 {
+	value, err := strconv.ParseInt(env(name, ""), 10, 64)
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	exitCodeError          = 1
+	exitCodePartialFailure = 2
+)
+
+type command func(ctx context.Context, args []string, stdin io.Reader) (any, error)
+
+// failedOutput is implemented by the outputs which can report a failure next to the results, e.g. the recipients
+// a message couldn't be sent to
+type failedOutput interface {
+	failed() bool
+}
+
+var commands = map[string]command{
+	"login":         login,
+	"send":          send,
+	"whoami":        whoami,
+	"verify-device": verifyDevice,
+	"serve":         serve,
+	"export-keys":   exportKeys,
+	"import-keys":   importKeys,
+}
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdin, os.Stdout))
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) int {
+	if len(args) == 0 {
+		return writeOutput(stdout, nil, errors.New("usage: matrix-notifier <login|send|whoami|verify-device|serve|export-keys|import-keys> [flags]"))
+	}
+
+	handler, ok := commands[args[0]]
+	if !ok {
+		return writeOutput(stdout, nil, fmt.Errorf("unknown command: %s", args[0]))
+	}
+
+	result, err := handler(ctx, args[1:], stdin)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+
+	return writeOutput(stdout, result, err)
+}
+
+func writeOutput(stdout io.Writer, result any, err error) int {
+	exitCode := 0
+	if err != nil {
+		result = errorOutput{Error: newError(err)}
+		exitCode = exitCodeError
+	} else if output, ok := result.(failedOutput); ok && output.failed() {
+		exitCode = exitCodePartialFailure
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if encodeErr := encoder.Encode(result); encodeErr != nil {
+		return exitCodeError
+	}
+
+	return exitCode
+}
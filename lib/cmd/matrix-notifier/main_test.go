@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"lib/api"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunUnknownCommand(t *testing.T) {
+	var stdout bytes.Buffer
+	if exitCode := run(context.Background(), []string{"unknown"}, strings.NewReader(""), &stdout); exitCode != 1 {
+		t.Fatalf("expected exit code 1, got %d", exitCode)
+	}
+
+	var output errorOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		t.Fatalf("expected JSON output, got %s", stdout.String())
+	}
+	if output.Error == nil || !strings.Contains(output.Error.Message, "unknown command") {
+		t.Fatalf("expected unknown command error, got %s", stdout.String())
+	}
+}
+
+func TestRunMissingFlag(t *testing.T) {
+	t.Setenv(envPrefix+"URL", "")
+	t.Setenv(envPrefix+"ACCESS_TOKEN", "")
+
+	var stdout bytes.Buffer
+	if exitCode := run(context.Background(), []string{"whoami", "-url", "https://example.org"}, strings.NewReader(""), &stdout); exitCode != 1 {
+		t.Fatalf("expected exit code 1, got %d", exitCode)
+	}
+
+	var output errorOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		t.Fatalf("expected JSON output, got %s", stdout.String())
+	}
+	if output.Error == nil || output.Error.Code != "invalid_request" {
+		t.Fatalf("expected invalid_request error, got %s", stdout.String())
+	}
+	if !strings.Contains(output.Error.Message, envPrefix+"ACCESS_TOKEN") {
+		t.Fatalf("expected the environment variable to be mentioned, got %s", output.Error.Message)
+	}
+}
+
+func TestRunWhoamiFromEnvironment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Fatalf("expected the access token from the environment, got %s", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"user_id":"@bot:example.org","device_id":"DEVICE"}`))
+	}))
+	defer server.Close()
+
+	t.Setenv(envPrefix+"URL", server.URL)
+	t.Setenv(envPrefix+"ACCESS_TOKEN", "token")
+
+	var stdout bytes.Buffer
+	if exitCode := run(context.Background(), []string{"whoami"}, strings.NewReader(""), &stdout); exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", exitCode, stdout.String())
+	}
+
+	var output whoamiOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		t.Fatalf("expected JSON output, got %s", stdout.String())
+	}
+	if output.UserId != "@bot:example.org" || output.DeviceId != "DEVICE" {
+		t.Fatalf("expected @bot:example.org/DEVICE, got %s/%s", output.UserId, output.DeviceId)
+	}
+}
+
+func TestRunExportKeysRequiresPassphrase(t *testing.T) {
+	t.Setenv(envPrefix+"PASSPHRASE", "")
+
+	var stdout bytes.Buffer
+	args := []string{"export-keys", "-url", "https://example.org", "-access-token", "token", "-database-dsn", "keys.db", "-pickle-key", "pickle", "-device-id", "DEVICE", "-file", "keys.txt"}
+	if exitCode := run(context.Background(), args, strings.NewReader(""), &stdout); exitCode != 1 {
+		t.Fatalf("expected exit code 1, got %d", exitCode)
+	}
+
+	var output errorOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		t.Fatalf("expected JSON output, got %s", stdout.String())
+	}
+	if output.Error == nil || output.Error.Code != "invalid_request" || !strings.Contains(output.Error.Message, envPrefix+"PASSPHRASE") {
+		t.Fatalf("expected the missing passphrase to be reported, got %s", stdout.String())
+	}
+}
+
+func TestRunServeNegativeQueueSize(t *testing.T) {
+	t.Setenv(envPrefix+"QUEUE_SIZE", "-1")
+
+	var stdout bytes.Buffer
+	args := []string{"serve", "-url", "https://example.org", "-access-token", "token", "-database-dsn", "notifier.db", "-recovery-key", "recovery", "-pickle-key", "pickle", "-device-id", "DEVICE", "-auth-token", "secret"}
+	if exitCode := run(context.Background(), args, strings.NewReader(""), &stdout); exitCode != 1 {
+		t.Fatalf("expected exit code 1, got %d", exitCode)
+	}
+
+	var output errorOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		t.Fatalf("expected JSON output, got %s", stdout.String())
+	}
+	if output.Error == nil || output.Error.Code != "invalid_request" || !strings.Contains(output.Error.Message, "queue-size") {
+		t.Fatalf("expected the negative queue size to be reported, got %s", stdout.String())
+	}
+}
+
+func TestWriteOutputPartialFailure(t *testing.T) {
+	cases := []struct {
+		output   sendOutput
+		expected int
+	}{
+		{sendOutput{Results: []api.Result{{Recipient: "!a:b", EventId: "$sent"}}}, 0},
+		{sendOutput{Results: []api.Result{{Recipient: "!a:b", EventId: "$sent"}, {Recipient: "!c:d", Error: &api.Error{Code: "room_not_found"}}}}, exitCodePartialFailure},
+	}
+
+	for _, testCase := range cases {
+		var stdout bytes.Buffer
+		if exitCode := writeOutput(&stdout, testCase.output, nil); exitCode != testCase.expected {
+			t.Fatalf("expected exit code %d, got %d", testCase.expected, exitCode)
+		}
+
+		var output sendOutput
+		if err := json.Unmarshal(stdout.Bytes(), &output); err != nil || len(output.Results) != len(testCase.output.Results) {
+			t.Fatalf("expected the results on stdout, got %s", stdout.String())
+		}
+	}
+}
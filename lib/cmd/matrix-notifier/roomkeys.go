@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"lib/matrix"
+	"os"
+
+	"maunium.net/go/mautrix/id"
+)
+
+type exportKeysOutput struct {
+	File  string `json:"file"`
+	Count int    `json:"count"`
+}
+
+type importKeysOutput struct {
+	Imported int `json:"imported"`
+	Total    int `json:"total"`
+}
+
+func exportKeys(ctx context.Context, args []string, _ io.Reader) (any, error) {
+	var cfg config
+	var passphrase, file string
+
+	flags := flag.NewFlagSet("export-keys", flag.ContinueOnError)
+	cfg.register(flags)
+	cfg.registerDevice(flags)
+	flags.StringVar(&passphrase, "passphrase", env("PASSPHRASE", ""), "the passphrase the key export is encrypted with (env "+envPrefix+"PASSPHRASE)")
+	flags.StringVar(&file, "file", "", "the file the key export is written to")
+	if err := parse(flags, args, "url", "access-token", "database-dsn", "pickle-key", "device-id", "passphrase", "file"); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := cfg.context(ctx)
+	defer cancel()
+
+	export, count, err := matrix.ExportRoomKeys(
+		ctx,
+		cfg.databaseDsn,
+		cfg.accessToken,
+		[]byte(cfg.pickleKey),
+		cfg.url,
+		id.DeviceID(cfg.deviceId),
+		passphrase,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.WriteFile(file, export, 0o600); err != nil {
+		return nil, err
+	}
+
+	return exportKeysOutput{File: file, Count: count}, nil
+}
+
+func importKeys(ctx context.Context, args []string, stdin io.Reader) (any, error) {
+	var cfg config
+	var passphrase, file string
+
+	flags := flag.NewFlagSet("import-keys", flag.ContinueOnError)
+	cfg.register(flags)
+	cfg.registerDevice(flags)
+	flags.StringVar(&passphrase, "passphrase", env("PASSPHRASE", ""), "the passphrase the key export is encrypted with (env "+envPrefix+"PASSPHRASE)")
+	flags.StringVar(&file, "file", "-", "the key export file, read from the standard input if -")
+	if err := parse(flags, args, "url", "access-token", "database-dsn", "pickle-key", "device-id", "passphrase"); err != nil {
+		return nil, err
+	}
+
+	var export []byte
+	var err error
+	if file == "-" {
+		export, err = io.ReadAll(stdin)
+	} else {
+		export, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := cfg.context(ctx)
+	defer cancel()
+
+	imported, total, err := matrix.ImportRoomKeys(
+		ctx,
+		cfg.databaseDsn,
+		cfg.accessToken,
+		[]byte(cfg.pickleKey),
+		cfg.url,
+		id.DeviceID(cfg.deviceId),
+		passphrase,
+		export,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return importKeysOutput{Imported: imported, Total: total}, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"lib/matrix"
+	"lib/server"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+type serveOutput struct {
+	Listen  string `json:"listen"`
+	Stopped bool   `json:"stopped"`
+}
+
+func serve(ctx context.Context, args []string, _ io.Reader) (any, error) {
+	var cfg config
+	var listen, authToken string
+	var queueSize int
+	var inviteUsers, inviteServers, inviteRooms string
+	var deviceTrust string
+
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	cfg.register(flags)
+	cfg.registerDevice(flags)
+	flags.StringVar(&listen, "listen", env("LISTEN", ":8080"), "the address to listen on (env "+envPrefix+"LISTEN)")
+	flags.StringVar(&authToken, "auth-token", env("AUTH_TOKEN", ""), "the bearer token the callers must provide (env "+envPrefix+"AUTH_TOKEN)")
+	flags.IntVar(&queueSize, "queue-size", int(envInt("QUEUE_SIZE", 100)), "the maximum number of waiting messages (env "+envPrefix+"QUEUE_SIZE)")
+	flags.StringVar(&inviteUsers, "invite-users", env("INVITE_USERS", ""), "comma separated user IDs whose invites are accepted (env "+envPrefix+"INVITE_USERS)")
+	flags.StringVar(&inviteServers, "invite-servers", env("INVITE_SERVERS", ""), "comma separated homeservers whose users' invites are accepted (env "+envPrefix+"INVITE_SERVERS)")
+	flags.StringVar(&inviteRooms, "invite-rooms", env("INVITE_ROOMS", ""), "comma separated room ID patterns whose invites are accepted (env "+envPrefix+"INVITE_ROOMS)")
+	flags.StringVar(&deviceTrust, "device-trust", env("DEVICE_TRUST", "all"), "which devices receive the room keys: all, cross_signed or tofu (env "+envPrefix+"DEVICE_TRUST)")
+	if err := parse(flags, args, "url", "access-token", "database-dsn", "recovery-key", "pickle-key", "device-id", "auth-token"); err != nil {
+		return nil, err
+	}
+	if queueSize < 0 {
+		return nil, invalidRequestError{err: fmt.Errorf("the -queue-size flag or the %sQUEUE_SIZE environment variable must not be negative", envPrefix)}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	openCtx, cancel := cfg.context(ctx)
+	defer cancel()
+
+	session, err := matrix.OpenSession(
+		openCtx,
+		cfg.databaseDsn,
+		cfg.accessToken,
+		cfg.recoveryKey,
+		[]byte(cfg.pickleKey),
+		cfg.url,
+		id.DeviceID(cfg.deviceId),
+		nil,
+		matrix.NewInvitePolicy(matrix.SplitList(inviteUsers), matrix.SplitList(inviteServers), matrix.SplitList(inviteRooms)),
+		matrix.DeviceTrustMode(deviceTrust),
+		false,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	// the queue keeps being processed until the requests accepted before the shutdown are answered
+	runCtx, stopRun := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopRun()
+
+	relay := server.NewServer(session, authToken, queueSize)
+	go relay.Run(runCtx)
+
+	httpServer := &http.Server{
+		Addr:              listen,
+		Handler:           relay,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
+		return nil, err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancelShutdown()
+	if err = httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return nil, err
+	}
+
+	return serveOutput{Listen: listen, Stopped: true}, nil
+}
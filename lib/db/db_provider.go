@@ -1,26 +1,60 @@
 package db
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
 	"go.mau.fi/util/dbutil"
 )
 
-var providerList []Provider
+var ErrUnsupportedDsn = errors.New("no database provider supports the DSN")
+
+var (
+	providerList     []Provider
+	providerListLock sync.RWMutex
+)
 
 type Provider interface {
-	supports(dsn string) bool
+	Supports(dsn string) bool
 	Get(dsn string) (*dbutil.Database, error)
 }
 
-func init() {
-	providerList = make([]Provider, 0)
+// SchemeProvider is implemented by the providers which list their schemes when no provider supports a DSN
+type SchemeProvider interface {
+	Schemes() []string
+}
+
+// Register adds a provider to the list, a provider registered later takes precedence over the earlier ones, so it can
+// override the built-in providers. Registering nil panics, the same way as registering a nil database driver does
+func Register(provider Provider) {
+	if provider == nil {
+		panic("db: Register provider is nil")
+	}
+
+	providerListLock.Lock()
+	defer providerListLock.Unlock()
+
+	providerList = append(providerList, provider)
 }
 
-func FindProvider(dsn string) Provider {
-	for _, provider := range providerList {
-		if provider.supports(dsn) {
-			return provider
+func FindProvider(dsn string) (Provider, error) {
+	providerListLock.RLock()
+	defer providerListLock.RUnlock()
+
+	schemes := make([]string, 0, len(providerList))
+	for _, provider := range slices.Backward(providerList) {
+		if provider.Supports(dsn) {
+			return provider, nil
+		}
+		if schemeProvider, ok := provider.(SchemeProvider); ok {
+			for _, scheme := range schemeProvider.Schemes() {
+				schemes = append(schemes, scheme+"://")
+			}
 		}
 	}
 
-	return nil
+	return nil, fmt.Errorf("%w, supported schemes: %s", ErrUnsupportedDsn, strings.Join(schemes, ", "))
 }
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.mau.fi/util/dbutil"
+)
+
+type memoryProvider struct {
+}
+
+func (receiver *memoryProvider) Supports(dsn string) bool {
+	return strings.HasPrefix(dsn, "memory://")
+}
+
+func (receiver *memoryProvider) Schemes() []string {
+	return []string{"memory"}
+}
+
+func (receiver *memoryProvider) Get(dsn string) (*dbutil.Database, error) {
+	return nil, errors.New("not implemented")
+}
+
+// sqliteOverride replaces the built-in sqlite provider and doesn't list its schemes
+type sqliteOverride struct {
+}
+
+func (receiver *sqliteOverride) Supports(dsn string) bool {
+	return strings.HasPrefix(dsn, "sqlite://")
+}
+
+func (receiver *sqliteOverride) Get(dsn string) (*dbutil.Database, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestFindProvider(t *testing.T) {
+	provider, err := FindProvider("/tmp/db.sqlite3")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := provider.(*SqliteProvider); !ok {
+		t.Fatalf("expected sqlite provider, got %T", provider)
+	}
+
+	provider, err = FindProvider("postgresql://localhost/notifier")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := provider.(*PostgresProvider); !ok {
+		t.Fatalf("expected postgres provider, got %T", provider)
+	}
+}
+
+func TestFindProviderUnsupported(t *testing.T) {
+	_, err := FindProvider("mysql://localhost/notifier")
+	if !errors.Is(err, ErrUnsupportedDsn) {
+		t.Fatalf("expected ErrUnsupportedDsn, got %v", err)
+	}
+
+	for _, scheme := range []string{"sqlite://", "postgres://", "postgresql://"} {
+		if !strings.Contains(err.Error(), scheme) {
+			t.Fatalf("expected error to list %s, got %v", scheme, err)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	original := providerList
+	t.Cleanup(func() { providerList = original })
+
+	_, err := FindProvider("memory://notifier")
+	if !errors.Is(err, ErrUnsupportedDsn) {
+		t.Fatalf("expected ErrUnsupportedDsn before registering, got %v", err)
+	}
+
+	Register(&memoryProvider{})
+
+	provider, err := FindProvider("memory://notifier")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := provider.(*memoryProvider); !ok {
+		t.Fatalf("expected memory provider, got %T", provider)
+	}
+}
+
+func TestRegisterOverridesEarlierProviders(t *testing.T) {
+	original := providerList
+	t.Cleanup(func() { providerList = original })
+
+	Register(&sqliteOverride{})
+
+	provider, err := FindProvider("sqlite:///tmp/db.sqlite3")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := provider.(*sqliteOverride); !ok {
+		t.Fatalf("expected the later provider, got %T", provider)
+	}
+
+	_, err = FindProvider("mysql://localhost/notifier")
+	if !errors.Is(err, ErrUnsupportedDsn) || !strings.Contains(err.Error(), "postgres://") {
+		t.Fatalf("expected the schemes of the other providers, got %v", err)
+	}
+}
+
+func TestRegisterNil(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected registering nil to panic")
+		}
+	}()
+
+	Register(nil)
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/lib/pq"
+	"go.mau.fi/util/dbutil"
+)
+
+type PostgresProvider struct {
+}
+
+func (receiver *PostgresProvider) Supports(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+func (receiver *PostgresProvider) Schemes() []string {
+	return []string{"postgres", "postgresql"}
+}
+
+func (receiver *PostgresProvider) Get(dsn string) (*dbutil.Database, error) {
+	dsn, schema, err := parsePostgresDsn(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if schema != "" {
+		_, err = db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", pq.QuoteIdentifier(schema)))
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	return dbutil.NewWithDB(db, "postgres")
+}
+
+// parsePostgresDsn converts the schema query parameter to a search_path runtime parameter,
+// the returned schema is the one that should be created if it doesn't exist yet
+func parsePostgresDsn(dsn string) (string, string, error) {
+	uri, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+
+	query := uri.Query()
+	schema := query.Get("schema")
+	if schema == "" {
+		return dsn, "", nil
+	}
+	if query.Has("search_path") {
+		return "", "", errors.New("the schema and search_path parameters cannot be used at the same time")
+	}
+
+	query.Del("schema")
+	query.Set("search_path", schema)
+	uri.RawQuery = query.Encode()
+
+	return uri.String(), schema, nil
+}
+
+func init() {
+	Register(&PostgresProvider{})
+}
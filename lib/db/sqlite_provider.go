@@ -15,10 +15,14 @@ import (
 type SqliteProvider struct {
 }
 
-func (receiver *SqliteProvider) supports(dsn string) bool {
+func (receiver *SqliteProvider) Supports(dsn string) bool {
 	return strings.HasPrefix(dsn, "sqlite://") || strings.HasPrefix(dsn, "/")
 }
 
+func (receiver *SqliteProvider) Schemes() []string {
+	return []string{"sqlite"}
+}
+
 func (receiver *SqliteProvider) Get(dsn string) (*dbutil.Database, error) {
 	if strings.HasPrefix(dsn, "sqlite://") {
 		dsn = dsn[len("sqlite://"):]
@@ -52,5 +56,5 @@ func (receiver *SqliteProvider) Get(dsn string) (*dbutil.Database, error) {
 }
 
 func init() {
-	providerList = append(providerList, &SqliteProvider{})
+	Register(&SqliteProvider{})
 }
@@ -45,7 +45,7 @@ func TestDsn(t *testing.T) {
 			expectedFile = helper.ToPointer(fmt.Sprintf("%s/db%d.sqlite3", tempDir, i+1))
 		}
 		provider := &SqliteProvider{}
-		if !provider.supports(dsn) {
+		if !provider.Supports(dsn) {
 			t.Fatalf("provider does not support %s", dsn)
 		}
 
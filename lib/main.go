@@ -3,9 +3,14 @@ package main
 // #cgo LDFLAGS: -L${SRCDIR}/out -lolm -Wl,-rpath,'$ORIGIN'
 import "C"
 import (
+	"encoding/json"
+	"fmt"
+	"lib/api"
 	"lib/matrix"
 	"lib/types"
+	"unsafe"
 
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
@@ -14,19 +19,86 @@ func SendMessage(
 	messageType *C.char,
 	renderingType *C.char,
 	message *C.char,
-	recipient *C.char,
+	recipients *C.char,
 	databaseDsn *C.char,
 	accessToken *C.char,
 	recoveryKey *C.char,
 	pickleKey *C.char,
 	url *C.char,
 	deviceId *C.char,
+	idempotencyKey *C.char,
+	timeoutMs C.longlong,
 	err **C.char,
 ) *C.char {
-	result, sendErr := matrix.SendMessage(
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	results, sendErr := matrix.SendMessage(
+		ctx,
 		types.MessageType(C.GoString(messageType)),
 		types.RenderingType(C.GoString(renderingType)),
 		C.GoString(message),
+		splitList(recipients),
+		C.GoString(databaseDsn),
+		C.GoString(accessToken),
+		C.GoString(recoveryKey),
+		[]byte(C.GoString(pickleKey)),
+		C.GoString(url),
+		id.DeviceID(C.GoString(deviceId)),
+		&matrix.MessageOptions{IdempotencyKey: C.GoString(idempotencyKey)},
+		nil,
+		nil,
+	)
+	if sendErr != nil {
+		setError(err, sendErr)
+		return C.CString("")
+	}
+
+	result, encodeErr := encodeRecipientResults(results)
+	if encodeErr != nil {
+		setError(err, encodeErr)
+	}
+
+	return C.CString(result)
+}
+
+//export SendAttachment
+func SendAttachment(
+	messageType *C.char,
+	fileName *C.char,
+	mimeType *C.char,
+	data *C.char,
+	dataLength C.int,
+	recipient *C.char,
+	databaseDsn *C.char,
+	accessToken *C.char,
+	recoveryKey *C.char,
+	pickleKey *C.char,
+	url *C.char,
+	deviceId *C.char,
+	idempotencyKey *C.char,
+	timeoutMs C.longlong,
+	err **C.char,
+) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	attachmentData, sendErr := goBytes(data, dataLength)
+	if sendErr != nil {
+		setError(err, sendErr)
+		return C.CString("")
+	}
+
+	result, sendErr := matrix.SendAttachment(
+		ctx,
+		types.MessageType(C.GoString(messageType)),
+		&matrix.Attachment{
+			FileName: C.GoString(fileName),
+			MimeType: C.GoString(mimeType),
+			Data:     attachmentData,
+		},
 		C.GoString(recipient),
 		C.GoString(databaseDsn),
 		C.GoString(accessToken),
@@ -34,19 +106,265 @@ func SendMessage(
 		[]byte(C.GoString(pickleKey)),
 		C.GoString(url),
 		id.DeviceID(C.GoString(deviceId)),
+		&matrix.MessageOptions{IdempotencyKey: C.GoString(idempotencyKey)},
+		nil,
 		nil,
 	)
 
 	if sendErr != nil {
-		*err = C.CString(sendErr.Error())
+		setError(err, sendErr)
+	}
+
+	return C.CString(result)
+}
+
+//export EditMessage
+func EditMessage(
+	messageType *C.char,
+	renderingType *C.char,
+	message *C.char,
+	recipient *C.char,
+	eventId *C.char,
+	databaseDsn *C.char,
+	accessToken *C.char,
+	recoveryKey *C.char,
+	pickleKey *C.char,
+	url *C.char,
+	deviceId *C.char,
+	timeoutMs C.longlong,
+	err **C.char,
+) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	result, editErr := matrix.EditMessage(
+		ctx,
+		types.MessageType(C.GoString(messageType)),
+		types.RenderingType(C.GoString(renderingType)),
+		C.GoString(message),
+		C.GoString(recipient),
+		id.EventID(C.GoString(eventId)),
+		C.GoString(databaseDsn),
+		C.GoString(accessToken),
+		C.GoString(recoveryKey),
+		[]byte(C.GoString(pickleKey)),
+		C.GoString(url),
+		id.DeviceID(C.GoString(deviceId)),
+		nil,
+	)
+
+	if editErr != nil {
+		setError(err, editErr)
+	}
+
+	return C.CString(result)
+}
+
+//export RedactMessage
+func RedactMessage(
+	recipient *C.char,
+	eventId *C.char,
+	reason *C.char,
+	databaseDsn *C.char,
+	accessToken *C.char,
+	recoveryKey *C.char,
+	pickleKey *C.char,
+	url *C.char,
+	deviceId *C.char,
+	timeoutMs C.longlong,
+	err **C.char,
+) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	result, redactErr := matrix.RedactMessage(
+		ctx,
+		C.GoString(recipient),
+		id.EventID(C.GoString(eventId)),
+		C.GoString(reason),
+		C.GoString(databaseDsn),
+		C.GoString(accessToken),
+		C.GoString(recoveryKey),
+		[]byte(C.GoString(pickleKey)),
+		C.GoString(url),
+		id.DeviceID(C.GoString(deviceId)),
+		nil,
+	)
+
+	if redactErr != nil {
+		setError(err, redactErr)
 	}
 
 	return C.CString(result)
 }
 
+//export React
+func React(
+	recipient *C.char,
+	eventId *C.char,
+	key *C.char,
+	databaseDsn *C.char,
+	accessToken *C.char,
+	recoveryKey *C.char,
+	pickleKey *C.char,
+	url *C.char,
+	deviceId *C.char,
+	timeoutMs C.longlong,
+	err **C.char,
+) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	result, reactErr := matrix.React(
+		ctx,
+		C.GoString(recipient),
+		id.EventID(C.GoString(eventId)),
+		C.GoString(key),
+		C.GoString(databaseDsn),
+		C.GoString(accessToken),
+		C.GoString(recoveryKey),
+		[]byte(C.GoString(pickleKey)),
+		C.GoString(url),
+		id.DeviceID(C.GoString(deviceId)),
+		nil,
+	)
+
+	if reactErr != nil {
+		setError(err, reactErr)
+	}
+
+	return C.CString(result)
+}
+
+//export Unreact
+func Unreact(
+	recipient *C.char,
+	eventId *C.char,
+	key *C.char,
+	databaseDsn *C.char,
+	accessToken *C.char,
+	recoveryKey *C.char,
+	pickleKey *C.char,
+	url *C.char,
+	deviceId *C.char,
+	timeoutMs C.longlong,
+	err **C.char,
+) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	result, reactErr := matrix.Unreact(
+		ctx,
+		C.GoString(recipient),
+		id.EventID(C.GoString(eventId)),
+		C.GoString(key),
+		C.GoString(databaseDsn),
+		C.GoString(accessToken),
+		C.GoString(recoveryKey),
+		[]byte(C.GoString(pickleKey)),
+		C.GoString(url),
+		id.DeviceID(C.GoString(deviceId)),
+		nil,
+	)
+
+	if reactErr != nil {
+		setError(err, reactErr)
+	}
+
+	return C.CString(result)
+}
+
+//export ExportRoomKeys
+func ExportRoomKeys(
+	databaseDsn *C.char,
+	accessToken *C.char,
+	pickleKey *C.char,
+	url *C.char,
+	deviceId *C.char,
+	passphrase *C.char,
+	timeoutMs C.longlong,
+	err **C.char,
+) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	export, _, exportErr := matrix.ExportRoomKeys(
+		ctx,
+		C.GoString(databaseDsn),
+		C.GoString(accessToken),
+		[]byte(C.GoString(pickleKey)),
+		C.GoString(url),
+		id.DeviceID(C.GoString(deviceId)),
+		C.GoString(passphrase),
+		nil,
+	)
+
+	if exportErr != nil {
+		setError(err, exportErr)
+	}
+
+	return C.CString(string(export))
+}
+
+//export ImportRoomKeys
+func ImportRoomKeys(
+	databaseDsn *C.char,
+	accessToken *C.char,
+	pickleKey *C.char,
+	url *C.char,
+	deviceId *C.char,
+	passphrase *C.char,
+	export *C.char,
+	timeoutMs C.longlong,
+	err **C.char,
+) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	imported, total, importErr := matrix.ImportRoomKeys(
+		ctx,
+		C.GoString(databaseDsn),
+		C.GoString(accessToken),
+		[]byte(C.GoString(pickleKey)),
+		C.GoString(url),
+		id.DeviceID(C.GoString(deviceId)),
+		C.GoString(passphrase),
+		[]byte(C.GoString(export)),
+		nil,
+	)
+
+	if importErr != nil {
+		setError(err, importErr)
+		return C.CString("")
+	}
+
+	serialized, encodeErr := json.Marshal(importedRoomKeys{Imported: imported, Total: total})
+	if encodeErr != nil {
+		setError(err, encodeErr)
+	}
+
+	return C.CString(string(serialized))
+}
+
+//export Execute
+func Execute(request *C.char) *C.char {
+	return C.CString(string(api.Execute([]byte(C.GoString(request)), nil)))
+}
+
 //export Login
-func Login(homeserver, username, password *C.char, err **C.char, deviceId **C.char, accessToken **C.char) {
+func Login(homeserver, username, password *C.char, timeoutMs C.longlong, err **C.char, deviceId **C.char, accessToken **C.char) {
+	initOutPointers(err, deviceId, accessToken)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
 	deviceIdStr, accessTokenStr, errLogin := matrix.Login(
+		ctx,
 		C.GoString(homeserver),
 		C.GoString(username),
 		C.GoString(password),
@@ -54,12 +372,457 @@ func Login(homeserver, username, password *C.char, err **C.char, deviceId **C.ch
 	)
 
 	if errLogin != nil {
-		*err = C.CString(errLogin.Error())
+		setError(err, errLogin)
 		return
 	}
 
-	*deviceId = C.CString(string(deviceIdStr))
-	*accessToken = C.CString(accessTokenStr)
+	setString(deviceId, string(deviceIdStr))
+	setString(accessToken, accessTokenStr)
+}
+
+//export OpenSession
+func OpenSession(
+	databaseDsn *C.char,
+	accessToken *C.char,
+	recoveryKey *C.char,
+	pickleKey *C.char,
+	url *C.char,
+	deviceId *C.char,
+	inviteUserIds *C.char,
+	inviteServers *C.char,
+	inviteRoomPatterns *C.char,
+	deviceTrust *C.char,
+	receiveMessages C.int,
+	timeoutMs C.longlong,
+	err **C.char,
+) C.ulonglong {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	session, openErr := matrix.OpenSession(
+		ctx,
+		C.GoString(databaseDsn),
+		C.GoString(accessToken),
+		C.GoString(recoveryKey),
+		[]byte(C.GoString(pickleKey)),
+		C.GoString(url),
+		id.DeviceID(C.GoString(deviceId)),
+		nil,
+		matrix.NewInvitePolicy(splitList(inviteUserIds), splitList(inviteServers), splitList(inviteRoomPatterns)),
+		matrix.DeviceTrustMode(C.GoString(deviceTrust)),
+		receiveMessages != 0,
+		nil,
+	)
+
+	if openErr != nil {
+		setError(err, openErr)
+		return 0
+	}
+
+	return C.ulonglong(storeSession(session))
+}
+
+//export SessionSend
+func SessionSend(
+	handle C.ulonglong,
+	messageType *C.char,
+	renderingType *C.char,
+	message *C.char,
+	recipient *C.char,
+	threadRootEventId *C.char,
+	replyToEventId *C.char,
+	mentionUserIds *C.char,
+	mentionRoom C.int,
+	idempotencyKey *C.char,
+	timeoutMs C.longlong,
+	err **C.char,
+) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	session, sendErr := findSession(uint64(handle))
+	if sendErr != nil {
+		setError(err, sendErr)
+		return C.CString("")
+	}
+
+	result, sendErr := session.SendMessage(
+		ctx,
+		types.MessageType(C.GoString(messageType)),
+		types.RenderingType(C.GoString(renderingType)),
+		C.GoString(message),
+		C.GoString(recipient),
+		messageOptions(threadRootEventId, replyToEventId, mentionUserIds, mentionRoom, idempotencyKey),
+	)
+
+	if sendErr != nil {
+		setError(err, sendErr)
+	}
+
+	return C.CString(result)
+}
+
+//export SessionSendAttachment
+func SessionSendAttachment(
+	handle C.ulonglong,
+	messageType *C.char,
+	fileName *C.char,
+	mimeType *C.char,
+	data *C.char,
+	dataLength C.int,
+	recipient *C.char,
+	threadRootEventId *C.char,
+	replyToEventId *C.char,
+	mentionUserIds *C.char,
+	mentionRoom C.int,
+	idempotencyKey *C.char,
+	timeoutMs C.longlong,
+	err **C.char,
+) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	session, sendErr := findSession(uint64(handle))
+	if sendErr != nil {
+		setError(err, sendErr)
+		return C.CString("")
+	}
+	attachmentData, sendErr := goBytes(data, dataLength)
+	if sendErr != nil {
+		setError(err, sendErr)
+		return C.CString("")
+	}
+
+	result, sendErr := session.SendAttachment(
+		ctx,
+		types.MessageType(C.GoString(messageType)),
+		&matrix.Attachment{
+			FileName: C.GoString(fileName),
+			MimeType: C.GoString(mimeType),
+			Data:     attachmentData,
+		},
+		C.GoString(recipient),
+		messageOptions(threadRootEventId, replyToEventId, mentionUserIds, mentionRoom, idempotencyKey),
+	)
+
+	if sendErr != nil {
+		setError(err, sendErr)
+	}
+
+	return C.CString(result)
+}
+
+//export SessionEdit
+func SessionEdit(
+	handle C.ulonglong,
+	messageType *C.char,
+	renderingType *C.char,
+	message *C.char,
+	recipient *C.char,
+	eventId *C.char,
+	timeoutMs C.longlong,
+	err **C.char,
+) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	session, editErr := findSession(uint64(handle))
+	if editErr != nil {
+		setError(err, editErr)
+		return C.CString("")
+	}
+
+	result, editErr := session.EditMessage(
+		ctx,
+		types.MessageType(C.GoString(messageType)),
+		types.RenderingType(C.GoString(renderingType)),
+		C.GoString(message),
+		C.GoString(recipient),
+		id.EventID(C.GoString(eventId)),
+	)
+
+	if editErr != nil {
+		setError(err, editErr)
+	}
+
+	return C.CString(result)
+}
+
+//export SessionRedact
+func SessionRedact(handle C.ulonglong, recipient *C.char, eventId *C.char, reason *C.char, timeoutMs C.longlong, err **C.char) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	session, redactErr := findSession(uint64(handle))
+	if redactErr != nil {
+		setError(err, redactErr)
+		return C.CString("")
+	}
+
+	result, redactErr := session.RedactMessage(
+		ctx,
+		C.GoString(recipient),
+		id.EventID(C.GoString(eventId)),
+		C.GoString(reason),
+	)
+
+	if redactErr != nil {
+		setError(err, redactErr)
+	}
+
+	return C.CString(result)
+}
+
+//export SessionReact
+func SessionReact(handle C.ulonglong, recipient *C.char, eventId *C.char, key *C.char, timeoutMs C.longlong, err **C.char) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	session, reactErr := findSession(uint64(handle))
+	if reactErr != nil {
+		setError(err, reactErr)
+		return C.CString("")
+	}
+
+	result, reactErr := session.React(
+		ctx,
+		C.GoString(recipient),
+		id.EventID(C.GoString(eventId)),
+		C.GoString(key),
+	)
+
+	if reactErr != nil {
+		setError(err, reactErr)
+	}
+
+	return C.CString(result)
+}
+
+//export SessionUnreact
+func SessionUnreact(handle C.ulonglong, recipient *C.char, eventId *C.char, key *C.char, timeoutMs C.longlong, err **C.char) *C.char {
+	initOutPointers(err)
+	ctx, cancel := api.ContextWithTimeout(int64(timeoutMs))
+	defer cancel()
+
+	session, reactErr := findSession(uint64(handle))
+	if reactErr != nil {
+		setError(err, reactErr)
+		return C.CString("")
+	}
+
+	result, reactErr := session.Unreact(
+		ctx,
+		C.GoString(recipient),
+		id.EventID(C.GoString(eventId)),
+		C.GoString(key),
+	)
+
+	if reactErr != nil {
+		setError(err, reactErr)
+	}
+
+	return C.CString(result)
+}
+
+//export SessionFetchEvents
+func SessionFetchEvents(handle C.ulonglong, since C.ulonglong, err **C.char) *C.char {
+	initOutPointers(err)
+
+	session, fetchErr := findSession(uint64(handle))
+	if fetchErr != nil {
+		setError(err, fetchErr)
+		return C.CString("")
+	}
+
+	result, fetchErr := encodeIncomingMessages(session.FetchEvents(uint64(since)))
+	if fetchErr != nil {
+		setError(err, fetchErr)
+	}
+
+	return C.CString(result)
+}
+
+//export SessionInviteDecisions
+func SessionInviteDecisions(handle C.ulonglong, err **C.char) *C.char {
+	initOutPointers(err)
+
+	session, decisionsErr := findSession(uint64(handle))
+	if decisionsErr != nil {
+		setError(err, decisionsErr)
+		return C.CString("")
+	}
+
+	result, decisionsErr := encodeInviteDecisions(session.InviteDecisions())
+	if decisionsErr != nil {
+		setError(err, decisionsErr)
+	}
+
+	return C.CString(result)
+}
+
+//export CloseSession
+func CloseSession(handle C.ulonglong, err **C.char) {
+	initOutPointers(err)
+
+	session, closeErr := removeSession(uint64(handle))
+	if closeErr == nil {
+		closeErr = session.Close()
+	}
+
+	if closeErr != nil {
+		setError(err, closeErr)
+	}
+}
+
+func messageOptions(
+	threadRootEventId *C.char,
+	replyToEventId *C.char,
+	mentionUserIds *C.char,
+	mentionRoom C.int,
+	idempotencyKey *C.char,
+) *matrix.MessageOptions {
+	options := &matrix.MessageOptions{
+		ThreadRootEventId: id.EventID(C.GoString(threadRootEventId)),
+		ReplyToEventId:    id.EventID(C.GoString(replyToEventId)),
+		MentionRoom:       mentionRoom != 0,
+		IdempotencyKey:    C.GoString(idempotencyKey),
+	}
+
+	for _, userId := range splitList(mentionUserIds) {
+		options.MentionUserIds = append(options.MentionUserIds, id.UserID(userId))
+	}
+
+	return options
+}
+
+// goBytes copies the data passed by the host, C.GoBytes panics on a negative length or on NULL data with a length
+func goBytes(data *C.char, length C.int) ([]byte, error) {
+	if length < 0 || (data == nil && length > 0) {
+		return nil, &matrix.Error{
+			Category: matrix.ErrorCategoryInvalidRequest,
+			Err:      fmt.Errorf("invalid data of length %d", length),
+		}
+	}
+
+	return C.GoBytes(unsafe.Pointer(data), length), nil
+}
+
+func splitList(value *C.char) []string {
+	return matrix.SplitList(C.GoString(value))
+}
+
+type importedRoomKeys struct {
+	Imported int `json:"imported"`
+	Total    int `json:"total"`
+}
+
+type recipientResult struct {
+	EventId  string               `json:"event_id,omitempty"`
+	Attempts int                  `json:"attempts,omitempty"`
+	Withheld []api.WithheldDevice `json:"withheld,omitempty"`
+	Error    string               `json:"error,omitempty"`
+}
+
+func encodeRecipientResults(results map[string]matrix.RecipientResult) (string, error) {
+	encoded := make(map[string]recipientResult, len(results))
+	for recipient, result := range results {
+		withheld := api.NewResult(recipient, result).Withheld
+		if result.Err != nil {
+			encoded[recipient] = recipientResult{Attempts: result.Attempts, Withheld: withheld, Error: result.Err.Error()}
+		} else {
+			encoded[recipient] = recipientResult{EventId: result.EventId, Attempts: result.Attempts, Withheld: withheld}
+		}
+	}
+
+	serialized, err := json.Marshal(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	return string(serialized), nil
+}
+
+type incomingMessage struct {
+	Sequence          uint64            `json:"sequence"`
+	EventId           id.EventID        `json:"event_id"`
+	RoomId            id.RoomID         `json:"room_id"`
+	Sender            id.UserID         `json:"sender"`
+	SenderDevice      id.DeviceID       `json:"sender_device,omitempty"`
+	TimestampMs       int64             `json:"timestamp_ms"`
+	MessageType       event.MessageType `json:"message_type"`
+	Body              string            `json:"body"`
+	FormattedBody     string            `json:"formatted_body,omitempty"`
+	ThreadRootEventId id.EventID        `json:"thread_root_event_id,omitempty"`
+	ReplyToEventId    id.EventID        `json:"reply_to_event_id,omitempty"`
+	ReplacesEventId   id.EventID        `json:"replaces_event_id,omitempty"`
+	Encrypted         bool              `json:"encrypted"`
+	Verification      string            `json:"verification"`
+}
+
+func encodeIncomingMessages(messages []matrix.IncomingMessage) (string, error) {
+	encoded := make([]incomingMessage, 0, len(messages))
+	for _, message := range messages {
+		encoded = append(encoded, incomingMessage{
+			Sequence:          message.Sequence,
+			EventId:           message.EventId,
+			RoomId:            message.RoomId,
+			Sender:            message.Sender,
+			SenderDevice:      message.SenderDevice,
+			TimestampMs:       message.Timestamp.UnixMilli(),
+			MessageType:       message.MessageType,
+			Body:              message.Body,
+			FormattedBody:     message.FormattedBody,
+			ThreadRootEventId: message.ThreadRootEventId,
+			ReplyToEventId:    message.ReplyToEventId,
+			ReplacesEventId:   message.ReplacesEventId,
+			Encrypted:         message.Encrypted,
+			Verification:      message.Verification.String(),
+		})
+	}
+
+	serialized, err := json.Marshal(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	return string(serialized), nil
+}
+
+type inviteDecision struct {
+	RoomId   id.RoomID `json:"room_id"`
+	Inviter  id.UserID `json:"inviter"`
+	Accepted bool      `json:"accepted"`
+	Reason   string    `json:"reason"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func encodeInviteDecisions(decisions []matrix.InviteDecision) (string, error) {
+	encoded := make([]inviteDecision, 0, len(decisions))
+	for _, decision := range decisions {
+		item := inviteDecision{
+			RoomId:   decision.RoomId,
+			Inviter:  decision.Inviter,
+			Accepted: decision.Accepted,
+			Reason:   decision.Reason,
+		}
+		if decision.Err != nil {
+			item.Error = decision.Err.Error()
+		}
+		encoded = append(encoded, item)
+	}
+
+	serialized, err := json.Marshal(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	return string(serialized), nil
 }
 
 func main() {}
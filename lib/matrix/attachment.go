@@ -0,0 +1,194 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"lib/types"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	thumbnailMaxWidth  = 800
+	thumbnailMaxHeight = 600
+	// thumbnailMaxPixels limits the images decoded to create a thumbnail, the decoded image takes 4 bytes per pixel
+	thumbnailMaxPixels = 40_000_000
+)
+
+type Attachment struct {
+	FileName string
+	MimeType string
+	Data     []byte
+}
+
+func LoadAttachment(path string) (*Attachment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Attachment{
+		FileName: filepath.Base(path),
+		Data:     data,
+	}, nil
+}
+
+func (receiver *Attachment) detectMimeType() string {
+	if receiver.MimeType != "" {
+		return receiver.MimeType
+	}
+
+	if byExtension := mime.TypeByExtension(filepath.Ext(receiver.FileName)); byExtension != "" {
+		return byExtension
+	}
+
+	return http.DetectContentType(receiver.Data)
+}
+
+func createAttachmentContent(
+	ctx context.Context,
+	client *mautrix.Client,
+	retryPolicy RetryPolicy,
+	messageType types.MessageType,
+	file *Attachment,
+) (*event.MessageEventContent, error) {
+	if file == nil || len(file.Data) == 0 {
+		return nil, newError(ErrorCategoryInvalidRequest, errors.New("the attachment is empty"))
+	}
+
+	fileName := file.FileName
+	if fileName == "" {
+		fileName = "attachment"
+	}
+
+	info := &event.FileInfo{
+		MimeType: file.detectMimeType(),
+		Size:     len(file.Data),
+	}
+
+	encryptedFile, err := uploadEncrypted(ctx, client, retryPolicy, file.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if messageType == types.MessageTypeImage {
+		err = addImageMetadata(ctx, client, retryPolicy, info, file.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &event.MessageEventContent{
+		MsgType:  event.MessageType(messageType),
+		Body:     fileName,
+		FileName: fileName,
+		Info:     info,
+		File:     encryptedFile,
+	}, nil
+}
+
+// uploadEncrypted encrypts the data once and retries only the upload
+func uploadEncrypted(ctx context.Context, client *mautrix.Client, retryPolicy RetryPolicy, data []byte) (*event.EncryptedFileInfo, error) {
+	file := attachment.NewEncryptedFile()
+	ciphertext := bytes.Clone(data)
+	file.EncryptInPlace(ciphertext)
+
+	var resp *mautrix.RespMediaUpload
+	_, err := retry(ctx, retryPolicy, func() (err error) {
+		resp, err = client.UploadBytes(ctx, ciphertext, "application/octet-stream")
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &event.EncryptedFileInfo{
+		EncryptedFile: *file,
+		URL:           resp.ContentURI.CUString(),
+	}, nil
+}
+
+func addImageMetadata(ctx context.Context, client *mautrix.Client, retryPolicy RetryPolicy, info *event.FileInfo, data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// not an image we can parse, send it without dimensions
+		return nil
+	}
+	info.Width = config.Width
+	info.Height = config.Height
+	if !canDecodeThumbnail(config) {
+		// decoding it could exhaust the memory, send it without a thumbnail
+		return nil
+	}
+
+	thumbnail, err := createThumbnail(data)
+	if err != nil || thumbnail == nil {
+		return nil
+	}
+
+	var encoded bytes.Buffer
+	err = jpeg.Encode(&encoded, thumbnail, &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil
+	}
+
+	thumbnailFile, err := uploadEncrypted(ctx, client, retryPolicy, encoded.Bytes())
+	if err != nil {
+		return err
+	}
+
+	info.ThumbnailFile = thumbnailFile
+	info.ThumbnailInfo = &event.FileInfo{
+		MimeType: "image/jpeg",
+		Width:    thumbnail.Bounds().Dx(),
+		Height:   thumbnail.Bounds().Dy(),
+		Size:     encoded.Len(),
+	}
+
+	return nil
+}
+
+// canDecodeThumbnail checks the dimensions from the image header before the whole image is decoded
+func canDecodeThumbnail(config image.Config) bool {
+	return config.Width > 0 && config.Height > 0 && config.Width <= thumbnailMaxPixels/config.Height
+}
+
+// createThumbnail returns nil if the image is already small enough to be used as its own thumbnail
+func createThumbnail(data []byte) (image.Image, error) {
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= thumbnailMaxWidth && height <= thumbnailMaxHeight {
+		return nil, nil
+	}
+
+	scale := min(float64(thumbnailMaxWidth)/float64(width), float64(thumbnailMaxHeight)/float64(height))
+	targetWidth := max(int(float64(width)*scale), 1)
+	targetHeight := max(int(float64(height)*scale), 1)
+
+	target := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		sourceY := bounds.Min.Y + y*height/targetHeight
+		for x := 0; x < targetWidth; x++ {
+			sourceX := bounds.Min.X + x*width/targetWidth
+			target.Set(x, y, source.At(sourceX, sourceY))
+		}
+	}
+
+	return target, nil
+}
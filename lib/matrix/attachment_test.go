@@ -0,0 +1,182 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"lib/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func newUploadServer(t *testing.T, uploads *[][]byte) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/upload") {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		*uploads = append(*uploads, body)
+		writeJSON(t, w, map[string]string{"content_uri": "mxc://example.com/media"})
+	}))
+}
+
+func TestCreateAttachmentContentFile(t *testing.T) {
+	var uploads [][]byte
+	server := newUploadServer(t, &uploads)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+	data := []byte("id,value\n1,2\n")
+
+	content, err := createAttachmentContent(context.Background(), client, testRetryPolicy, types.MessageTypeFile, &Attachment{
+		FileName: "export.csv",
+		Data:     data,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if content.MsgType != "m.file" {
+		t.Fatalf("expected msgtype m.file, got %s", content.MsgType)
+	}
+	if content.Body != "export.csv" || content.FileName != "export.csv" {
+		t.Fatalf("expected file name export.csv, got body %q and filename %q", content.Body, content.FileName)
+	}
+	if content.URL != "" {
+		t.Fatalf("expected no plaintext url, got %s", content.URL)
+	}
+	if content.File == nil || content.File.URL != "mxc://example.com/media" {
+		t.Fatalf("expected encrypted file info with uploaded url, got %+v", content.File)
+	}
+	if !strings.HasPrefix(content.Info.MimeType, "text/csv") {
+		t.Fatalf("expected text/csv mime type, got %s", content.Info.MimeType)
+	}
+	if content.Info.Size != len(data) {
+		t.Fatalf("expected size %d, got %d", len(data), content.Info.Size)
+	}
+
+	if len(uploads) != 1 {
+		t.Fatalf("expected 1 upload, got %d", len(uploads))
+	}
+	if bytes.Equal(uploads[0], data) {
+		t.Fatalf("expected uploaded data to be encrypted")
+	}
+	serialized, err := json.Marshal(content.File)
+	if err != nil {
+		t.Fatalf("failed to serialize file info: %v", err)
+	}
+	var received event.EncryptedFileInfo
+	if err := json.Unmarshal(serialized, &received); err != nil {
+		t.Fatalf("failed to deserialize file info: %v", err)
+	}
+	if err := received.DecryptInPlace(uploads[0]); err != nil {
+		t.Fatalf("failed to decrypt uploaded data: %v", err)
+	}
+	if !bytes.Equal(uploads[0], data) {
+		t.Fatalf("expected decrypted data to match original")
+	}
+}
+
+func TestCreateAttachmentContentImageWithThumbnail(t *testing.T) {
+	var uploads [][]byte
+	server := newUploadServer(t, &uploads)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+
+	source := image.NewRGBA(image.Rect(0, 0, 1600, 400))
+	for x := 0; x < 1600; x++ {
+		source.Set(x, 0, color.RGBA{R: 255, A: 255})
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, source); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	content, err := createAttachmentContent(context.Background(), client, testRetryPolicy, types.MessageTypeImage, &Attachment{
+		FileName: "screenshot.png",
+		Data:     encoded.Bytes(),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if content.Info.Width != 1600 || content.Info.Height != 400 {
+		t.Fatalf("expected 1600x400, got %dx%d", content.Info.Width, content.Info.Height)
+	}
+	if content.Info.MimeType != "image/png" {
+		t.Fatalf("expected image/png mime type, got %s", content.Info.MimeType)
+	}
+	if content.Info.ThumbnailFile == nil {
+		t.Fatalf("expected thumbnail to be uploaded")
+	}
+	thumbnailInfo := content.Info.ThumbnailInfo
+	if thumbnailInfo == nil || thumbnailInfo.Width != 800 || thumbnailInfo.Height != 200 {
+		t.Fatalf("expected 800x200 thumbnail, got %+v", thumbnailInfo)
+	}
+	if len(uploads) != 2 {
+		t.Fatalf("expected 2 uploads, got %d", len(uploads))
+	}
+}
+
+func TestCanDecodeThumbnail(t *testing.T) {
+	cases := map[image.Config]bool{
+		{Width: 1600, Height: 400}:    true,
+		{Width: 8000, Height: 5000}:   true,
+		{Width: 8000, Height: 5001}:   false,
+		{Width: 20000, Height: 20000}: false,
+		{Width: 0, Height: 400}:       false,
+		{Width: -1, Height: -1}:       false,
+	}
+
+	for config, expected := range cases {
+		if allowed := canDecodeThumbnail(config); allowed != expected {
+			t.Fatalf("expected %v for %dx%d, got %v", expected, config.Width, config.Height, allowed)
+		}
+	}
+}
+
+func TestCreateAttachmentContentEmpty(t *testing.T) {
+	_, err := createAttachmentContent(context.Background(), nil, testRetryPolicy, types.MessageTypeFile, &Attachment{FileName: "empty.txt"})
+	if err == nil {
+		t.Fatalf("expected error for empty attachment")
+	}
+}
+
+func TestLoadAttachment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.tar.gz")
+	if err := os.WriteFile(path, []byte("content"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	file, err := LoadAttachment(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if file.FileName != "logs.tar.gz" {
+		t.Fatalf("expected file name logs.tar.gz, got %s", file.FileName)
+	}
+	if string(file.Data) != "content" {
+		t.Fatalf("expected file content to be loaded, got %q", file.Data)
+	}
+
+	_, err = LoadAttachment(filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Fatalf("expected error for missing file")
+	}
+}
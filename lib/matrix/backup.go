@@ -0,0 +1,236 @@
+package matrix
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// keyBackupBatchSize is the number of sessions uploaded in one request
+const keyBackupBatchSize = 256
+
+var ErrKeyBackupMismatch = errors.New("the key backup on the server doesn't use the backup key from the secret storage")
+
+// keyBackup uploads the megolm sessions to the server-side key backup, so that other sessions of the account
+// can decrypt the sent messages even if the local store is lost
+type keyBackup struct {
+	version id.KeyBackupVersion
+	key     *backup.MegolmBackupKey
+	lock    sync.Mutex
+}
+
+// enableKeyBackup returns nil if there's no backup key in the secret storage
+func enableKeyBackup(ctx context.Context, client *mautrix.Client, machine *crypto.OlmMachine, recoveryKey string) (*keyBackup, error) {
+	key, err := loadBackupKey(ctx, machine, recoveryKey)
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	version, err := keyBackupVersion(ctx, client, machine, key, recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyBackup{version: version, key: key}, nil
+}
+
+// loadBackupKey reads the backup key from the secret storage once and keeps it in the crypto store afterwards
+func loadBackupKey(ctx context.Context, machine *crypto.OlmMachine, recoveryKey string) (*backup.MegolmBackupKey, error) {
+	encoded, err := machine.CryptoStore.GetSecret(ctx, id.SecretMegolmBackupV1)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	if encoded == "" {
+		ssssKey, err := secretStorageKey(ctx, machine, recoveryKey)
+		if err != nil {
+			return nil, err
+		}
+
+		decrypted, err := machine.SSSS.GetDecryptedAccountData(ctx, event.AccountDataMegolmBackupKey, ssssKey)
+		if errors.Is(err, mautrix.MNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = string(decrypted)
+		if err = machine.CryptoStore.PutSecret(ctx, id.SecretMegolmBackupV1, encoded); err != nil {
+			return nil, storeError(err)
+		}
+	}
+
+	decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup key: %w", err)
+	}
+
+	return backup.MegolmBackupKeyFromBytes(decoded)
+}
+
+// keyBackupVersion returns the latest backup version, a new version is created if there's none yet. A new version
+// is always signed with the master key, other clients don't trust an unsigned backup
+func keyBackupVersion(
+	ctx context.Context,
+	client *mautrix.Client,
+	machine *crypto.OlmMachine,
+	key *backup.MegolmBackupKey,
+	recoveryKey string,
+) (id.KeyBackupVersion, error) {
+	publicKey := id.Ed25519(base64.RawStdEncoding.EncodeToString(key.PublicKey().Bytes()))
+
+	latest, err := client.GetKeyBackupLatestVersion(ctx)
+	if err == nil {
+		if latest.Algorithm != id.KeyBackupAlgorithmMegolmBackupV1 || latest.AuthData.PublicKey != publicKey {
+			return "", ErrKeyBackupMismatch
+		}
+
+		return latest.Version, nil
+	}
+	if !errors.Is(err, mautrix.MNotFound) {
+		return "", err
+	}
+
+	if err = loadCrossSigningKeys(ctx, machine, recoveryKey); err != nil {
+		return "", fmt.Errorf("refusing to create an unsigned key backup version, the cross-signing keys are not available: %w", err)
+	}
+
+	authData := backup.MegolmAuthData{PublicKey: publicKey}
+	signature, err := machine.CrossSigningKeys.MasterKey.SignJSON(authData)
+	if err != nil {
+		return "", err
+	}
+	masterKey := machine.CrossSigningKeys.MasterKey.PublicKey()
+	authData.Signatures = map[id.UserID]map[id.KeyID]string{
+		client.UserID: {id.NewKeyID(id.KeyAlgorithmEd25519, masterKey.String()): signature},
+	}
+
+	created, err := client.CreateKeyBackupVersion(ctx, &mautrix.ReqRoomKeysVersionCreate[backup.MegolmAuthData]{
+		Algorithm: id.KeyBackupAlgorithmMegolmBackupV1,
+		AuthData:  authData,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return created.Version, nil
+}
+
+// loadCrossSigningKeys fetches the private cross-signing keys from the secret storage, the bootstrap skips it when
+// the device is already signed
+func loadCrossSigningKeys(ctx context.Context, machine *crypto.OlmMachine, recoveryKey string) error {
+	if machine.CrossSigningKeys != nil {
+		return nil
+	}
+
+	ssssKey, err := secretStorageKey(ctx, machine, recoveryKey)
+	if err != nil {
+		return err
+	}
+
+	return machine.FetchCrossSigningKeysFromSSSS(ctx, ssssKey)
+}
+
+func secretStorageKey(ctx context.Context, machine *crypto.OlmMachine, recoveryKey string) (*ssss.Key, error) {
+	keyId, keyData, err := machine.SSSS.GetDefaultKeyData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keyData.VerifyRecoveryKey(keyId, recoveryKey)
+	if err != nil {
+		return nil, newError(ErrorCategoryRecoveryKeyInvalid, err)
+	}
+
+	return key, nil
+}
+
+// upload sends the sessions which are not in the backup yet in batches, each batch is marked as backed up once
+// the server has stored it, so a failure only leaves the remaining batches for the next upload
+func (receiver *keyBackup) upload(ctx context.Context, client *mautrix.Client, store crypto.Store, ownIdentityKey id.SenderKey) (count int, err error) {
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+
+	sessions, err := store.GetGroupSessionsWithoutKeyBackupVersion(ctx, receiver.version).AsList()
+	if err != nil {
+		return
+	}
+
+	for batch := range slices.Chunk(sessions, keyBackupBatchSize) {
+		if err = receiver.uploadBatch(ctx, client, store, ownIdentityKey, batch); err != nil {
+			return
+		}
+		count += len(batch)
+	}
+
+	return
+}
+
+func (receiver *keyBackup) uploadBatch(
+	ctx context.Context,
+	client *mautrix.Client,
+	store crypto.Store,
+	ownIdentityKey id.SenderKey,
+	sessions []*crypto.InboundGroupSession,
+) error {
+	request := &mautrix.ReqKeyBackup{Rooms: make(map[id.RoomID]mautrix.ReqRoomKeyBackup)}
+	for _, session := range sessions {
+		firstKnownIndex := session.Internal.FirstKnownIndex()
+		sessionKey, err := session.Internal.Export(firstKnownIndex)
+		if err != nil {
+			return err
+		}
+
+		encrypted, err := backup.EncryptSessionData(receiver.key, backup.MegolmSessionData{
+			Algorithm:          id.AlgorithmMegolmV1,
+			ForwardingKeyChain: session.ForwardingChains,
+			SenderClaimedKeys:  backup.SenderClaimedKeys{Ed25519: session.SigningKey},
+			SenderKey:          session.SenderKey,
+			SessionKey:         string(sessionKey),
+		})
+		if err != nil {
+			return err
+		}
+		sessionData, err := json.Marshal(encrypted)
+		if err != nil {
+			return err
+		}
+
+		room, ok := request.Rooms[session.RoomID]
+		if !ok {
+			room = mautrix.ReqRoomKeyBackup{Sessions: make(map[id.SessionID]mautrix.ReqKeyBackupData)}
+			request.Rooms[session.RoomID] = room
+		}
+		room.Sessions[session.ID()] = mautrix.ReqKeyBackupData{
+			FirstMessageIndex: int(firstKnownIndex),
+			ForwardedCount:    len(session.ForwardingChains),
+			IsVerified:        session.SenderKey == ownIdentityKey,
+			SessionData:       sessionData,
+		}
+	}
+
+	if _, err := client.PutKeysInBackup(ctx, receiver.version, request); err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		session.KeyBackupVersion = receiver.version
+		if err := store.PutGroupSession(ctx, session); err != nil {
+			return storeError(err)
+		}
+	}
+
+	return nil
+}
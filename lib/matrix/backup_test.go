@@ -0,0 +1,228 @@
+package matrix
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/id"
+)
+
+func newTestBackupKey(t *testing.T) (*backup.MegolmBackupKey, id.Ed25519) {
+	t.Helper()
+
+	key, err := backup.NewMegolmBackupKey()
+	if err != nil {
+		t.Fatalf("failed to generate backup key: %v", err)
+	}
+
+	return key, id.Ed25519(base64.RawStdEncoding.EncodeToString(key.PublicKey().Bytes()))
+}
+
+func TestLoadBackupKeyFromStore(t *testing.T) {
+	key, _ := newTestBackupKey(t)
+	machine := &crypto.OlmMachine{CryptoStore: crypto.NewMemoryStore(nil)}
+	_ = machine.CryptoStore.PutSecret(context.Background(), id.SecretMegolmBackupV1, base64.StdEncoding.EncodeToString(key.Bytes()))
+
+	loaded, err := loadBackupKey(context.Background(), machine, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !loaded.Equal(key.PrivateKey) {
+		t.Fatalf("expected the stored backup key to be loaded")
+	}
+}
+
+func TestKeyBackupVersion(t *testing.T) {
+	key, publicKey := newTestBackupKey(t)
+	_, otherPublicKey := newTestBackupKey(t)
+
+	notFound := map[string]any{"errcode": "M_NOT_FOUND", "error": "No current backup version"}
+	cases := []struct {
+		name        string
+		latest      any
+		status      int
+		crossSigned bool
+		expected    id.KeyBackupVersion
+		err         error
+	}{
+		{"existing", map[string]any{"algorithm": id.KeyBackupAlgorithmMegolmBackupV1, "version": "3", "auth_data": map[string]any{"public_key": publicKey}}, http.StatusOK, false, "3", nil},
+		{"other key", map[string]any{"algorithm": id.KeyBackupAlgorithmMegolmBackupV1, "version": "3", "auth_data": map[string]any{"public_key": otherPublicKey}}, http.StatusOK, false, "", ErrKeyBackupMismatch},
+		{"created", notFound, http.StatusNotFound, true, "4", nil},
+		{"unsigned", notFound, http.StatusNotFound, false, "", ssss.ErrNoDefaultKeyID},
+	}
+
+	for _, testCase := range cases {
+		var created *mautrix.ReqRoomKeysVersionCreate[backup.MegolmAuthData]
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/_matrix/client/v3/room_keys/version":
+				w.WriteHeader(testCase.status)
+				writeJSON(t, w, testCase.latest)
+			case r.Method == http.MethodPost && r.URL.Path == "/_matrix/client/v3/room_keys/version":
+				if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+					t.Fatalf("failed to decode request: %v", err)
+				}
+				writeJSON(t, w, map[string]any{"version": "4"})
+			case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/account_data/"):
+				w.WriteHeader(http.StatusNotFound)
+				writeJSON(t, w, map[string]any{"errcode": "M_NOT_FOUND", "error": "Account data not found"})
+			default:
+				t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+			}
+		}))
+
+		log := zerolog.Nop()
+		client := newTestClient(t, server, "@bot:example.org")
+		machine := crypto.NewOlmMachine(client, &log, crypto.NewMemoryStore(nil), mautrix.NewMemoryStateStore().(crypto.StateStore))
+		if testCase.crossSigned {
+			keys, err := machine.GenerateCrossSigningKeys()
+			if err != nil {
+				t.Fatalf("failed to generate cross-signing keys: %v", err)
+			}
+			machine.CrossSigningKeys = keys
+		}
+
+		version, err := keyBackupVersion(context.Background(), client, machine, key, "")
+		server.Close()
+
+		if !errors.Is(err, testCase.err) {
+			t.Fatalf("%s: expected error %v, got %v", testCase.name, testCase.err, err)
+		}
+		if version != testCase.expected {
+			t.Fatalf("%s: expected version %q, got %q", testCase.name, testCase.expected, version)
+		}
+		if testCase.expected == "4" && (created == nil || created.AuthData.PublicKey != publicKey || len(created.AuthData.Signatures["@bot:example.org"]) != 1) {
+			t.Fatalf("%s: expected the backup to be created with the backup key and signed, got %+v", testCase.name, created)
+		}
+		if testCase.expected == "" && created != nil {
+			t.Fatalf("%s: expected no backup version to be created, got %+v", testCase.name, created)
+		}
+	}
+}
+
+func TestKeyBackupUpload(t *testing.T) {
+	ctx := context.Background()
+	roomId := id.RoomID("!room:example.org")
+	key, _ := newTestBackupKey(t)
+
+	outbound, err := crypto.NewOutboundGroupSession(roomId, nil)
+	if err != nil {
+		t.Fatalf("failed to create outbound session: %v", err)
+	}
+	inbound, err := crypto.NewInboundGroupSession("sender", "signing", roomId, outbound.Internal.Key(), 0, 0, false)
+	if err != nil {
+		t.Fatalf("failed to create inbound session: %v", err)
+	}
+	store := crypto.NewMemoryStore(nil)
+	_ = store.PutGroupSession(ctx, inbound)
+
+	var uploads []mautrix.ReqKeyBackup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/_matrix/client/v3/room_keys/keys" || r.URL.Query().Get("version") != "1" {
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL)
+		}
+
+		var request mautrix.ReqKeyBackup
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		uploads = append(uploads, request)
+		writeJSON(t, w, map[string]any{"count": 1, "etag": "1"})
+	}))
+	defer server.Close()
+
+	keyBackup := &keyBackup{version: "1", key: key}
+	client := newTestClient(t, server, "@bot:example.org")
+
+	count, err := keyBackup.upload(ctx, client, store, "sender")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if count != 1 || len(uploads) != 1 {
+		t.Fatalf("expected one uploaded session, got %d in %d requests", count, len(uploads))
+	}
+
+	uploaded, ok := uploads[0].Rooms[roomId].Sessions[inbound.ID()]
+	if !ok || !uploaded.IsVerified {
+		t.Fatalf("expected the verified session in the upload, got %+v", uploads[0])
+	}
+	var encrypted backup.EncryptedSessionData[backup.MegolmSessionData]
+	if err := json.Unmarshal(uploaded.SessionData, &encrypted); err != nil {
+		t.Fatalf("failed to decode session data: %v", err)
+	}
+	decrypted, err := encrypted.Decrypt(key)
+	if err != nil {
+		t.Fatalf("failed to decrypt session data: %v", err)
+	}
+	if decrypted.SenderKey != "sender" || decrypted.SenderClaimedKeys.Ed25519 != "signing" || decrypted.SessionKey == "" {
+		t.Fatalf("unexpected session data %+v", decrypted)
+	}
+
+	count, err = keyBackup.upload(ctx, client, store, "sender")
+	if err != nil || count != 0 || len(uploads) != 1 {
+		t.Fatalf("expected the backed up session not to be uploaded again, got %d, %v", count, err)
+	}
+}
+
+func TestKeyBackupUploadMarksEachBatch(t *testing.T) {
+	ctx := context.Background()
+	roomId := id.RoomID("!room:example.org")
+	key, _ := newTestBackupKey(t)
+
+	store := crypto.NewMemoryStore(nil)
+	for range keyBackupBatchSize + 1 {
+		outbound, err := crypto.NewOutboundGroupSession(roomId, nil)
+		if err != nil {
+			t.Fatalf("failed to create outbound session: %v", err)
+		}
+		inbound, err := crypto.NewInboundGroupSession("sender", "signing", roomId, outbound.Internal.Key(), 0, 0, false)
+		if err != nil {
+			t.Fatalf("failed to create inbound session: %v", err)
+		}
+		_ = store.PutGroupSession(ctx, inbound)
+	}
+
+	var uploaded []int
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request mautrix.ReqKeyBackup
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if len(uploaded) == 1 && failing {
+			failing = false
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		uploaded = append(uploaded, len(request.Rooms[roomId].Sessions))
+		writeJSON(t, w, map[string]any{"count": len(request.Rooms[roomId].Sessions), "etag": "1"})
+	}))
+	defer server.Close()
+
+	keyBackup := &keyBackup{version: "1", key: key}
+	client := newTestClient(t, server, "@bot:example.org")
+
+	count, err := keyBackup.upload(ctx, client, store, "sender")
+	if err == nil || count != keyBackupBatchSize {
+		t.Fatalf("expected the first batch to be uploaded before the error, got %d, %v", count, err)
+	}
+
+	count, err = keyBackup.upload(ctx, client, store, "sender")
+	if err != nil || count != 1 {
+		t.Fatalf("expected only the remaining session to be uploaded, got %d, %v", count, err)
+	}
+	if len(uploaded) != 2 || uploaded[0] != keyBackupBatchSize || uploaded[1] != 1 {
+		t.Fatalf("expected batches of %d and 1 sessions, got %v", keyBackupBatchSize, uploaded)
+	}
+}
@@ -0,0 +1,108 @@
+package matrix
+
+import (
+	"context"
+	"lib/store"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+)
+
+// VerifyDevice runs only the cross-signing bootstrap of the device, without starting the sync loop
+func VerifyDevice(
+	ctx context.Context,
+	databaseDsn string,
+	accessToken string,
+	recoveryKey string,
+	pickleKey []byte,
+	url string,
+	deviceId id.DeviceID,
+	clientFactory MautrixFactory,
+) error {
+	client, _, crypto, database, notifierStore, err := prepareClient(ctx, databaseDsn, accessToken, pickleKey, url, deviceId, DefaultRetryPolicy, clientFactory)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	return bootstrapCrossSigning(ctx, client, crypto.Machine(), notifierStore, recoveryKey)
+}
+
+func bootstrapCrossSigning(
+	ctx context.Context,
+	client *mautrix.Client,
+	machine *crypto.OlmMachine,
+	notifierStore *store.NotifierStore,
+	recoveryKey string,
+) error {
+	masterKey, signed, err := fetchOwnDeviceSigningState(ctx, client)
+	if err != nil {
+		return err
+	}
+	if signed {
+		bootstrappedMasterKey, err := notifierStore.GetBootstrappedMasterKey(ctx, client.DeviceID)
+		if err != nil {
+			return err
+		}
+		if bootstrappedMasterKey == masterKey {
+			return nil
+		}
+	}
+
+	keyId, keyData, err := machine.SSSS.GetDefaultKeyData(ctx)
+	if err != nil {
+		return err
+	}
+	key, err := keyData.VerifyRecoveryKey(keyId, recoveryKey)
+	if err != nil {
+		return newError(ErrorCategoryRecoveryKeyInvalid, err)
+	}
+	err = machine.FetchCrossSigningKeysFromSSSS(ctx, key)
+	if err != nil {
+		return err
+	}
+	err = machine.SignOwnDevice(ctx, machine.OwnIdentity())
+	if err != nil {
+		return err
+	}
+	err = machine.SignOwnMasterKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	return notifierStore.SetBootstrappedMasterKey(ctx, client.DeviceID, machine.CrossSigningKeys.MasterKey.PublicKey())
+}
+
+// fetchOwnDeviceSigningState returns the current master key from the server and whether the current device
+// carries a signature from the current self-signing key
+func fetchOwnDeviceSigningState(ctx context.Context, client *mautrix.Client) (masterKey id.Ed25519, signed bool, err error) {
+	resp, err := client.QueryKeys(ctx, &mautrix.ReqQueryKeys{
+		DeviceKeys: mautrix.DeviceKeysRequest{
+			client.UserID: mautrix.DeviceIDList{client.DeviceID},
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	masterKeys, ok := resp.MasterKeys[client.UserID]
+	if !ok {
+		return
+	}
+	masterKey = masterKeys.FirstKey()
+
+	selfSigningKeys, ok := resp.SelfSigningKeys[client.UserID]
+	if !ok {
+		return
+	}
+	selfSigningKey := selfSigningKeys.FirstKey()
+
+	device, ok := resp.DeviceKeys[client.UserID][client.DeviceID]
+	if !ok {
+		return
+	}
+	_, signed = device.Signatures[client.UserID][id.NewKeyID(id.KeyAlgorithmEd25519, selfSigningKey.String())]
+
+	return
+}
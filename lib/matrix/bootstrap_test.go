@@ -0,0 +1,122 @@
+package matrix
+
+import (
+	"context"
+	"lib/db"
+	"lib/store"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newKeysServer(t *testing.T, masterKey string, deviceSigned bool) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/keys/query") {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+
+		signatures := map[string]map[string]string{
+			"@self:example.com": {"ed25519:DEVICE": "device-signature"},
+		}
+		if deviceSigned {
+			signatures["@self:example.com"]["ed25519:ssk"] = "ssk-signature"
+		}
+
+		writeJSON(t, w, map[string]any{
+			"device_keys": map[string]any{
+				"@self:example.com": map[string]any{
+					"DEVICE": map[string]any{
+						"user_id":    "@self:example.com",
+						"device_id":  "DEVICE",
+						"signatures": signatures,
+					},
+				},
+			},
+			"master_keys": map[string]any{
+				"@self:example.com": map[string]any{
+					"keys": map[string]string{"ed25519:" + masterKey: masterKey},
+				},
+			},
+			"self_signing_keys": map[string]any{
+				"@self:example.com": map[string]any{
+					"keys": map[string]string{"ed25519:ssk": "ssk"},
+				},
+			},
+		})
+	}))
+}
+
+func newTestNotifierStore(t *testing.T) *store.NotifierStore {
+	t.Helper()
+
+	database, err := (&db.SqliteProvider{}).Get(filepath.Join(t.TempDir(), "crypto.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	notifierStore := store.NewNotifierStore(database)
+	if err := notifierStore.Upgrade(context.Background()); err != nil {
+		t.Fatalf("failed to upgrade store: %v", err)
+	}
+
+	return notifierStore
+}
+
+func TestFetchOwnDeviceSigningState(t *testing.T) {
+	server := newKeysServer(t, "master", true)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+	client.DeviceID = "DEVICE"
+
+	masterKey, signed, err := fetchOwnDeviceSigningState(context.Background(), client)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if masterKey != "master" {
+		t.Fatalf("expected master key master, got %s", masterKey)
+	}
+	if !signed {
+		t.Fatalf("expected device to be signed")
+	}
+}
+
+func TestFetchOwnDeviceSigningStateUnsigned(t *testing.T) {
+	server := newKeysServer(t, "master", false)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+	client.DeviceID = "DEVICE"
+
+	_, signed, err := fetchOwnDeviceSigningState(context.Background(), client)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if signed {
+		t.Fatalf("expected device to not be signed")
+	}
+}
+
+func TestBootstrapCrossSigningSkipsKnownDevice(t *testing.T) {
+	server := newKeysServer(t, "master", true)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+	client.DeviceID = "DEVICE"
+
+	notifierStore := newTestNotifierStore(t)
+	if err := notifierStore.SetBootstrappedMasterKey(context.Background(), "DEVICE", "master"); err != nil {
+		t.Fatalf("failed to set master key: %v", err)
+	}
+
+	// the machine is nil, so any attempt to run the SSSS bootstrap would panic
+	err := bootstrapCrossSigning(context.Background(), client, nil, notifierStore, "recovery")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
@@ -8,13 +8,13 @@ import (
 	"maunium.net/go/mautrix/crypto/cryptohelper"
 )
 
-func initializeEncryption(client *mautrix.Client, pickleKey []byte, database *dbutil.Database) (*cryptohelper.CryptoHelper, error) {
+func initializeEncryption(ctx context.Context, client *mautrix.Client, pickleKey []byte, database *dbutil.Database) (*cryptohelper.CryptoHelper, error) {
 	helper, err := cryptohelper.NewCryptoHelper(client, pickleKey, database)
 	if err != nil {
 		return nil, err
 	}
 
-	err = helper.Init(context.Background())
+	err = helper.Init(ctx)
 	if err != nil {
 		return nil, err
 	}
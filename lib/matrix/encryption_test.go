@@ -1,6 +1,7 @@
 package matrix
 
 import (
+	"context"
 	"io"
 	"lib/db"
 	"net/http"
@@ -53,7 +54,7 @@ func TestInitializeEncryptionSuccess(t *testing.T) {
 
 	client := newLoggedInTestClient(t)
 
-	helper, err := initializeEncryption(client, []byte("secret"), database)
+	helper, err := initializeEncryption(context.Background(), client, []byte("secret"), database)
 	if err != nil {
 		t.Fatalf("initializeEncryption returned error: %v", err)
 	}
@@ -76,7 +77,7 @@ func TestInitializeEncryptionFailsWithoutSyncer(t *testing.T) {
 
 	database, _ := (&db.SqliteProvider{}).Get(filepath.Join(t.TempDir(), "crypto.db"))
 
-	_, err := initializeEncryption(client, []byte("secret"), database)
+	_, err := initializeEncryption(context.Background(), client, []byte("secret"), database)
 	if err == nil {
 		t.Fatalf("expected error when client syncer does not implement ExtensibleSyncer")
 	}
@@ -87,7 +88,7 @@ func TestInitializeEncryptionFailsWithEmptyPickleKey(t *testing.T) {
 
 	database, _ := (&db.SqliteProvider{}).Get(filepath.Join(t.TempDir(), "crypto.db"))
 
-	_, err := initializeEncryption(client, nil, database)
+	_, err := initializeEncryption(context.Background(), client, nil, database)
 	if err == nil {
 		t.Fatalf("expected error when pickle key is empty")
 	}
@@ -0,0 +1,156 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"lib/db"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"maunium.net/go/mautrix"
+)
+
+type ErrorCategory string
+
+const (
+	ErrorCategoryUnknown            ErrorCategory = "unknown"
+	ErrorCategoryInvalidRequest     ErrorCategory = "invalid_request"
+	ErrorCategoryInvalidRecipient   ErrorCategory = "invalid_recipient"
+	ErrorCategoryRoomNotFound       ErrorCategory = "room_not_found"
+	ErrorCategoryEventNotFound      ErrorCategory = "event_not_found"
+	ErrorCategoryForbidden          ErrorCategory = "forbidden"
+	ErrorCategoryRateLimited        ErrorCategory = "rate_limited"
+	ErrorCategoryAuthInvalidToken   ErrorCategory = "auth_invalid_token"
+	ErrorCategoryRecoveryKeyInvalid ErrorCategory = "recovery_key_invalid"
+	ErrorCategoryCryptoStoreError   ErrorCategory = "crypto_store_error"
+	ErrorCategoryInvalidConfig      ErrorCategory = "invalid_configuration"
+	ErrorCategoryNoRoomKeys         ErrorCategory = "no_room_keys"
+	ErrorCategoryNetwork            ErrorCategory = "network"
+	ErrorCategoryTimeout            ErrorCategory = "timeout"
+)
+
+type Error struct {
+	Category   ErrorCategory
+	ErrCode    string
+	HttpStatus int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func newError(category ErrorCategory, err error) *Error {
+	return &Error{Category: category, Err: err}
+}
+
+// storeError marks errors from the crypto initialization as store errors unless they come from the homeserver
+func storeError(err error) error {
+	var httpError mautrix.HTTPError
+	if errors.As(err, &httpError) {
+		return err
+	}
+
+	return newError(ErrorCategoryCryptoStoreError, err)
+}
+
+// eventError marks a missing target event, M_NOT_FOUND is classified as a missing room otherwise
+func eventError(err error) error {
+	if errors.Is(err, mautrix.MNotFound) {
+		return newError(ErrorCategoryEventNotFound, err)
+	}
+
+	return err
+}
+
+func (receiver *Error) Error() string {
+	return receiver.Err.Error()
+}
+
+func (receiver *Error) Unwrap() error {
+	return receiver.Err
+}
+
+// Retryable reports whether sending the same request again later may succeed
+func (receiver *Error) Retryable() bool {
+	switch receiver.Category {
+	case ErrorCategoryRateLimited, ErrorCategoryNetwork:
+		return true
+	case ErrorCategoryUnknown:
+		return receiver.HttpStatus >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
+
+// Classify returns the typed representation of any error returned by this package, the message stays the same
+func Classify(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	result := &Error{Category: ErrorCategoryUnknown, Err: err}
+
+	var httpError mautrix.HTTPError
+	if errors.As(err, &httpError) {
+		if httpError.Response != nil {
+			result.HttpStatus = httpError.Response.StatusCode
+		}
+		if httpError.RespError != nil {
+			result.ErrCode = httpError.RespError.ErrCode
+		}
+		result.RetryAfter = retryAfter(httpError)
+	}
+
+	var typed *Error
+	var powerLevelError *PowerLevelError
+	var networkError net.Error
+	switch {
+	// the context errors implement net.Error, but an expired timeout is not worth retrying
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		result.Category = ErrorCategoryTimeout
+	case errors.As(err, &typed):
+		result.Category = typed.Category
+		if typed.RetryAfter > 0 {
+			result.RetryAfter = typed.RetryAfter
+		}
+	case errors.As(err, &powerLevelError):
+		result.Category = ErrorCategoryForbidden
+	case errors.Is(err, ErrSessionClosed), errors.Is(err, ErrNoRecipients),
+		errors.Is(err, ErrNotRoomMember), errors.Is(err, ErrReactionNotFound):
+		result.Category = ErrorCategoryInvalidRequest
+	case errors.Is(err, db.ErrUnsupportedDsn):
+		result.Category = ErrorCategoryInvalidConfig
+	case errors.Is(err, ErrNoRoomKeys):
+		result.Category = ErrorCategoryNoRoomKeys
+	case errors.Is(err, mautrix.MUnknownToken), errors.Is(err, mautrix.MMissingToken):
+		result.Category = ErrorCategoryAuthInvalidToken
+	case errors.Is(err, mautrix.MLimitExceeded) || result.HttpStatus == http.StatusTooManyRequests:
+		result.Category = ErrorCategoryRateLimited
+	case errors.Is(err, mautrix.MForbidden):
+		result.Category = ErrorCategoryForbidden
+	case errors.Is(err, mautrix.MNotFound):
+		result.Category = ErrorCategoryRoomNotFound
+	case errors.As(err, &networkError):
+		result.Category = ErrorCategoryNetwork
+	case httpError.Response == nil && httpError.WrappedError != nil:
+		result.Category = ErrorCategoryNetwork
+	}
+
+	return result
+}
+
+func retryAfter(httpError mautrix.HTTPError) time.Duration {
+	if httpError.RespError != nil {
+		if milliseconds, ok := httpError.RespError.ExtraData["retry_after_ms"].(float64); ok {
+			return time.Duration(milliseconds) * time.Millisecond
+		}
+	}
+
+	if httpError.Response != nil {
+		if seconds, err := strconv.Atoi(httpError.Response.Header.Get("Retry-After")); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,126 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lib/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestClassifyHttpErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       map[string]any
+		category   ErrorCategory
+		errCode    string
+		retryAfter time.Duration
+		retryable  bool
+	}{
+		{"rate limited", http.StatusTooManyRequests, map[string]any{"errcode": "M_LIMIT_EXCEEDED", "error": "Too many requests", "retry_after_ms": 1500}, ErrorCategoryRateLimited, "M_LIMIT_EXCEEDED", 1500 * time.Millisecond, true},
+		{"invalid token", http.StatusUnauthorized, map[string]any{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid token"}, ErrorCategoryAuthInvalidToken, "M_UNKNOWN_TOKEN", 0, false},
+		{"forbidden", http.StatusForbidden, map[string]any{"errcode": "M_FORBIDDEN", "error": "Not in room"}, ErrorCategoryForbidden, "M_FORBIDDEN", 0, false},
+		{"not found", http.StatusNotFound, map[string]any{"errcode": "M_NOT_FOUND", "error": "Room alias not found"}, ErrorCategoryRoomNotFound, "M_NOT_FOUND", 0, false},
+		{"server error", http.StatusBadGateway, map[string]any{"errcode": "M_UNKNOWN", "error": "Bad gateway"}, ErrorCategoryUnknown, "M_UNKNOWN", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				writeJSON(t, w, test.body)
+			}))
+			defer server.Close()
+
+			client := newTestClient(t, server, "@self:example.com")
+			_, err := client.JoinedMembers(context.Background(), "!room:example.com")
+
+			classified := Classify(fmt.Errorf("wrapped: %w", err))
+			if classified.Category != test.category {
+				t.Fatalf("expected category %s, got %s", test.category, classified.Category)
+			}
+			if classified.ErrCode != test.errCode {
+				t.Fatalf("expected errcode %s, got %s", test.errCode, classified.ErrCode)
+			}
+			if classified.HttpStatus != test.status {
+				t.Fatalf("expected HTTP status %d, got %d", test.status, classified.HttpStatus)
+			}
+			if classified.RetryAfter != test.retryAfter {
+				t.Fatalf("expected retry after %s, got %s", test.retryAfter, classified.RetryAfter)
+			}
+			if classified.Retryable() != test.retryable {
+				t.Fatalf("expected retryable %t, got %t", test.retryable, classified.Retryable())
+			}
+		})
+	}
+}
+
+func TestClassifyNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	client := newTestClient(t, server, "@self:example.com")
+	server.Close()
+
+	_, err := client.JoinedMembers(context.Background(), "!room:example.com")
+	if category := Classify(err).Category; category != ErrorCategoryNetwork {
+		t.Fatalf("expected network category, got %s", category)
+	}
+}
+
+func TestClassifyTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	client := newTestClient(t, server, "@self:example.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.JoinedMembers(ctx, "!room:example.com")
+
+	classified := Classify(err)
+	if classified.Category != ErrorCategoryTimeout {
+		t.Fatalf("expected timeout category, got %s", classified.Category)
+	}
+	if classified.Retryable() {
+		t.Fatalf("expected the timeout not to be retryable")
+	}
+	if category := Classify(storeError(context.Canceled)).Category; category != ErrorCategoryTimeout {
+		t.Fatalf("expected timeout category for a cancelled context, got %s", category)
+	}
+}
+
+func TestClassifyLibraryErrors(t *testing.T) {
+	tests := []struct {
+		err      error
+		category ErrorCategory
+	}{
+		{newError(ErrorCategoryRecoveryKeyInvalid, errors.New("invalid key")), ErrorCategoryRecoveryKeyInvalid},
+		{&PowerLevelError{Err: mautrix.MForbidden}, ErrorCategoryForbidden},
+		{fmt.Errorf("%w: @user:example.com", ErrNotRoomMember), ErrorCategoryInvalidRequest},
+		{ErrSessionClosed, ErrorCategoryInvalidRequest},
+		{fmt.Errorf("%w, supported schemes: sqlite", db.ErrUnsupportedDsn), ErrorCategoryInvalidConfig},
+		{ErrNoRoomKeys, ErrorCategoryNoRoomKeys},
+		{eventError(mautrix.MNotFound), ErrorCategoryEventNotFound},
+		{errors.New("something else"), ErrorCategoryUnknown},
+	}
+
+	for _, test := range tests {
+		if category := Classify(test.err).Category; category != test.category {
+			t.Fatalf("expected category %s for %v, got %s", test.category, test.err, category)
+		}
+	}
+
+	_, err := resolveRecipient(context.Background(), nil, "room", testRetryPolicy)
+	if category := Classify(err).Category; category != ErrorCategoryInvalidRecipient {
+		t.Fatalf("expected invalid_recipient category, got %s", category)
+	}
+	if Classify(nil) != nil {
+		t.Fatalf("expected nil for nil error")
+	}
+}
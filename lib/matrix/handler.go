@@ -2,11 +2,9 @@ package matrix
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"lib/db"
 	"lib/types"
-	"sync"
+	"slices"
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
@@ -15,184 +13,270 @@ import (
 )
 
 func SendMessage(
+	ctx context.Context,
 	messageType types.MessageType,
 	renderingType types.RenderingType,
 	message string,
-	recipient string,
+	recipients []string,
 	databaseDsn string,
 	accessToken string,
 	recoveryKey string,
 	pickleKey []byte,
 	url string,
 	deviceId id.DeviceID,
+	options *MessageOptions,
+	retryPolicy *RetryPolicy,
 	clientFactory MautrixFactory,
-) (messageId string, err error) {
-	databaseProvider := db.FindProvider(databaseDsn)
-	if databaseProvider == nil {
-		err = errors.New("databaseProvider is nil, the databaseProvider DSN is invalid")
+) (results map[string]RecipientResult, err error) {
+	if len(recipients) == 0 {
+		err = ErrNoRecipients
 		return
 	}
-	database, err := databaseProvider.Get(databaseDsn)
+
+	var file *Attachment
+	if messageType.IsAttachment() {
+		file, err = LoadAttachment(message)
+		if err != nil {
+			return
+		}
+	}
+
+	session, err := OpenSession(ctx, databaseDsn, accessToken, recoveryKey, pickleKey, url, deviceId, retryPolicy, nil, "", false, clientFactory)
 	if err != nil {
 		return
 	}
+	defer session.Close()
 
-	if clientFactory == nil {
-		clientFactory = func() (*mautrix.Client, error) {
-			return mautrix.NewClient(url, "", accessToken)
-		}
+	return session.sendToRecipients(ctx, messageType, renderingType, message, file, recipients, options)
+}
+
+func SendAttachment(
+	ctx context.Context,
+	messageType types.MessageType,
+	file *Attachment,
+	recipient string,
+	databaseDsn string,
+	accessToken string,
+	recoveryKey string,
+	pickleKey []byte,
+	url string,
+	deviceId id.DeviceID,
+	options *MessageOptions,
+	retryPolicy *RetryPolicy,
+	clientFactory MautrixFactory,
+) (messageId string, err error) {
+	if !messageType.IsAttachment() {
+		err = newError(ErrorCategoryInvalidRequest, fmt.Errorf("message type %s cannot be used for attachments", messageType))
+		return
 	}
 
-	client, err := clientFactory()
+	session, err := OpenSession(ctx, databaseDsn, accessToken, recoveryKey, pickleKey, url, deviceId, retryPolicy, nil, "", false, clientFactory)
 	if err != nil {
 		return
 	}
-	whoami, err := client.Whoami(context.Background())
+	defer session.Close()
+
+	return session.SendAttachment(ctx, messageType, file, recipient, options)
+}
+
+func EditMessage(
+	ctx context.Context,
+	messageType types.MessageType,
+	renderingType types.RenderingType,
+	message string,
+	recipient string,
+	eventId id.EventID,
+	databaseDsn string,
+	accessToken string,
+	recoveryKey string,
+	pickleKey []byte,
+	url string,
+	deviceId id.DeviceID,
+	clientFactory MautrixFactory,
+) (messageId string, err error) {
+	session, err := OpenSession(ctx, databaseDsn, accessToken, recoveryKey, pickleKey, url, deviceId, nil, nil, "", false, clientFactory)
 	if err != nil {
 		return
 	}
-	client.UserID = whoami.UserID
+	defer session.Close()
 
-	syncer := mautrix.NewDefaultSyncer()
+	return session.EditMessage(ctx, messageType, renderingType, message, recipient, eventId)
+}
+
+func RedactMessage(
+	ctx context.Context,
+	recipient string,
+	eventId id.EventID,
+	reason string,
+	databaseDsn string,
+	accessToken string,
+	recoveryKey string,
+	pickleKey []byte,
+	url string,
+	deviceId id.DeviceID,
+	clientFactory MautrixFactory,
+) (redactionId string, err error) {
+	session, err := OpenSession(ctx, databaseDsn, accessToken, recoveryKey, pickleKey, url, deviceId, nil, nil, "", false, clientFactory)
+	if err != nil {
+		return
+	}
+	defer session.Close()
 
-	client.DeviceID = deviceId
-	client.Syncer = syncer
+	return session.RedactMessage(ctx, recipient, eventId, reason)
+}
 
-	crypto, err := initializeEncryption(client, pickleKey, database)
+func React(
+	ctx context.Context,
+	recipient string,
+	eventId id.EventID,
+	key string,
+	databaseDsn string,
+	accessToken string,
+	recoveryKey string,
+	pickleKey []byte,
+	url string,
+	deviceId id.DeviceID,
+	clientFactory MautrixFactory,
+) (reactionId string, err error) {
+	session, err := OpenSession(ctx, databaseDsn, accessToken, recoveryKey, pickleKey, url, deviceId, nil, nil, "", false, clientFactory)
 	if err != nil {
 		return
 	}
+	defer session.Close()
 
-	roomId, err := resolveRecipient(client, recipient)
+	return session.React(ctx, recipient, eventId, key)
+}
+
+func Unreact(
+	ctx context.Context,
+	recipient string,
+	eventId id.EventID,
+	key string,
+	databaseDsn string,
+	accessToken string,
+	recoveryKey string,
+	pickleKey []byte,
+	url string,
+	deviceId id.DeviceID,
+	clientFactory MautrixFactory,
+) (redactionId string, err error) {
+	session, err := OpenSession(ctx, databaseDsn, accessToken, recoveryKey, pickleKey, url, deviceId, nil, nil, "", false, clientFactory)
 	if err != nil {
 		return
 	}
+	defer session.Close()
 
-	readyChan := make(chan error, 1)
-	var onceSetupEncryption sync.Once
-
-	syncer.OnSync(func(ctx context.Context, resp *mautrix.RespSync, since string) bool {
-		onceSetupEncryption.Do(func() {
-			machine := crypto.Machine()
-			keyId, keyData, err := machine.SSSS.GetDefaultKeyData(ctx)
-			if err != nil {
-				readyChan <- err
-				return
-			}
-			key, err := keyData.VerifyRecoveryKey(keyId, recoveryKey)
-			if err != nil {
-				readyChan <- err
-				return
-			}
-			err = machine.FetchCrossSigningKeysFromSSSS(ctx, key)
-			if err != nil {
-				readyChan <- err
-				return
-			}
-			err = machine.SignOwnDevice(ctx, machine.OwnIdentity())
-			if err != nil {
-				readyChan <- err
-				return
-			}
-			err = machine.SignOwnMasterKey(ctx)
-			if err != nil {
-				readyChan <- err
-				return
-			}
-
-			readyChan <- nil
-		})
-
-		return true
-	})
-
-	errChan := make(chan error, 1)
-	go func() {
-		if err := client.Sync(); err != nil {
-			select {
-			case errChan <- err:
-			default:
-			}
-		}
-	}()
-	defer client.StopSync()
+	return session.Unreact(ctx, recipient, eventId, key)
+}
 
-	select {
-	case err = <-readyChan:
-	case err = <-errChan:
+// sendToRoom works on a copy of the content, so the content with the uploaded attachment can be sent again
+// on a retry without uploading the attachment again
+func sendToRoom(
+	ctx context.Context,
+	client *mautrix.Client,
+	roomId id.RoomID,
+	prepared *event.MessageEventContent,
+	options *MessageOptions,
+	transactionId string,
+) (response *mautrix.RespSendEvent, err error) {
+	content := cloneContent(prepared)
+
+	err = applyMentions(ctx, client, roomId, content, options)
+	if err != nil {
+		return
 	}
+
+	err = applyRelations(ctx, client, roomId, content, options)
 	if err != nil {
 		return
 	}
 
-	respChan := make(chan *mautrix.RespSendEvent, 1)
-	go func() {
-		var response *mautrix.RespSendEvent
-		var err error
+	return client.SendMessageEvent(
+		ctx,
+		roomId,
+		event.EventMessage,
+		content,
+		mautrix.ReqSendEvent{TransactionID: transactionId},
+	)
+}
 
-		_, err = client.State(context.Background(), roomId)
-		if err != nil {
-			select {
-			case errChan <- err:
-			default:
-			}
-			return
-		}
+func editInRoom(
+	ctx context.Context,
+	client *mautrix.Client,
+	roomId id.RoomID,
+	eventId id.EventID,
+	messageType types.MessageType,
+	renderingType types.RenderingType,
+	message string,
+	transactionId string,
+) (*mautrix.RespSendEvent, error) {
+	if messageType.IsAttachment() {
+		return nil, newError(ErrorCategoryInvalidRequest, fmt.Errorf("message type %s cannot be edited", messageType))
+	}
 
-		switch messageType {
-		case types.MessageTypeTextMessage:
-			var content event.MessageEventContent
-			switch renderingType {
-			case types.RenderingTypeHtml:
-				content = format.HTMLToContent(message)
-				break
-			case types.RenderingTypeMarkdown:
-				content = format.RenderMarkdown(message, true, true)
-				break
-			case types.RenderingTypePlainText:
-				content = format.TextToContent(message)
-				break
-			default:
-				select {
-				case errChan <- fmt.Errorf("unsupported rendering type: %s", renderingType):
-				default:
-				}
-				return
-			}
-
-			response, err = client.SendMessageEvent(
-				context.Background(),
-				roomId,
-				event.EventMessage,
-				content,
-			)
-			break
-		case types.MessageTypeNotice:
-			response, err = client.SendNotice(context.Background(), roomId, message)
-			break
-		default:
-			err = fmt.Errorf("unsupported message type: %s", messageType)
-			break
-		}
+	content, err := createContent(ctx, client, DefaultRetryPolicy, messageType, renderingType, message, nil)
+	if err != nil {
+		return nil, err
+	}
+	content.SetEdit(eventId)
 
+	return client.SendMessageEvent(ctx, roomId, event.EventMessage, content, mautrix.ReqSendEvent{TransactionID: transactionId})
+}
+
+func createContent(
+	ctx context.Context,
+	client *mautrix.Client,
+	retryPolicy RetryPolicy,
+	messageType types.MessageType,
+	renderingType types.RenderingType,
+	message string,
+	file *Attachment,
+) (*event.MessageEventContent, error) {
+	switch messageType {
+	case types.MessageTypeTextMessage:
+		content, err := renderContent(renderingType, message)
 		if err != nil {
-			select {
-			case errChan <- err:
-			default:
-			}
-			return
+			return nil, err
 		}
 
-		respChan <- response
-	}()
+		return &content, nil
+	case types.MessageTypeNotice:
+		return &event.MessageEventContent{
+			MsgType: event.MsgNotice,
+			Body:    message,
+		}, nil
+	case types.MessageTypeImage, types.MessageTypeFile, types.MessageTypeAudio, types.MessageTypeVideo:
+		return createAttachmentContent(ctx, client, retryPolicy, messageType, file)
+	default:
+		return nil, newError(ErrorCategoryInvalidRequest, fmt.Errorf("unsupported message type: %s", messageType))
+	}
+}
 
-	select {
-	case err = <-errChan:
-		break
-	case response := <-respChan:
-		messageId = string(response.EventID)
-		break
+// cloneContent copies the parts of the content the mentions and relations modify
+func cloneContent(content *event.MessageEventContent) *event.MessageEventContent {
+	cloned := *content
+	if content.Mentions != nil {
+		mentions := *content.Mentions
+		mentions.UserIDs = slices.Clone(content.Mentions.UserIDs)
+		cloned.Mentions = &mentions
+	}
+	if content.RelatesTo != nil {
+		relatesTo := *content.RelatesTo
+		cloned.RelatesTo = &relatesTo
 	}
 
-	return
+	return &cloned
+}
+
+func renderContent(renderingType types.RenderingType, message string) (event.MessageEventContent, error) {
+	switch renderingType {
+	case types.RenderingTypeHtml:
+		return format.HTMLToContent(message), nil
+	case types.RenderingTypeMarkdown:
+		return format.RenderMarkdown(message, true, true), nil
+	case types.RenderingTypePlainText:
+		return format.TextToContent(message), nil
+	default:
+		return event.MessageEventContent{}, newError(ErrorCategoryInvalidRequest, fmt.Errorf("unsupported rendering type: %s", renderingType))
+	}
 }
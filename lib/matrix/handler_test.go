@@ -0,0 +1,88 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"lib/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func newSendServer(t *testing.T, sent *[]map[string]any) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/send/") {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		var content map[string]any
+		if err := json.Unmarshal(body, &content); err != nil {
+			t.Fatalf("failed to unmarshal body: %v", err)
+		}
+		*sent = append(*sent, content)
+		writeJSON(t, w, map[string]string{"event_id": "$sent"})
+	}))
+}
+
+func TestCreateContentUnsupportedTypes(t *testing.T) {
+	_, err := createContent(context.Background(), nil, testRetryPolicy, types.MessageTypeTextMessage, "unknown", "hello", nil)
+	if err == nil {
+		t.Fatalf("expected error for unsupported rendering type")
+	}
+
+	_, err = createContent(context.Background(), nil, testRetryPolicy, "m.unknown", types.RenderingTypePlainText, "hello", nil)
+	if err == nil {
+		t.Fatalf("expected error for unsupported message type")
+	}
+}
+
+func TestEditInRoom(t *testing.T) {
+	var sent []map[string]any
+	server := newSendServer(t, &sent)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+
+	response, err := editInRoom(context.Background(), client, "!room:example.com", "$original", types.MessageTypeTextMessage, types.RenderingTypeMarkdown, "deploy **80%** done", "txn")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if response.EventID != "$sent" {
+		t.Fatalf("expected event ID $sent, got %s", response.EventID)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 sent event, got %d", len(sent))
+	}
+
+	relatesTo, _ := sent[0]["m.relates_to"].(map[string]any)
+	if relatesTo["rel_type"] != string(event.RelReplace) || relatesTo["event_id"] != "$original" {
+		t.Fatalf("expected m.replace relation to $original, got %v", sent[0]["m.relates_to"])
+	}
+	if sent[0]["body"] != "* deploy **80%** done" {
+		t.Fatalf("expected edit fallback body, got %v", sent[0]["body"])
+	}
+
+	newContent, _ := sent[0]["m.new_content"].(map[string]any)
+	if newContent["body"] != "deploy **80%** done" {
+		t.Fatalf("expected new content body, got %v", newContent["body"])
+	}
+	if newContent["formatted_body"] != "deploy <strong>80%</strong> done" {
+		t.Fatalf("expected rendered markdown in new content, got %v", newContent["formatted_body"])
+	}
+}
+
+func TestEditInRoomRejectsAttachments(t *testing.T) {
+	_, err := editInRoom(context.Background(), nil, "!room:example.com", "$original", types.MessageTypeImage, types.RenderingTypePlainText, "image.png", "txn")
+	if err == nil {
+		t.Fatalf("expected error when editing an attachment")
+	}
+}
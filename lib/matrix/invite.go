@@ -0,0 +1,141 @@
+package matrix
+
+import (
+	"context"
+	"path"
+	"slices"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const maxInviteDecisions = 100
+
+const (
+	InviteReasonAllowedUser   = "allowed_user"
+	InviteReasonAllowedServer = "allowed_server"
+	InviteReasonAllowedRoom   = "allowed_room"
+	InviteReasonNotAllowed    = "not_allowed"
+)
+
+// InvitePolicy accepts the invites matching any of the rules and rejects all other invites,
+// room patterns use the path.Match syntax, e.g. !*:example.org
+type InvitePolicy struct {
+	UserIds      []id.UserID
+	Servers      []string
+	RoomPatterns []string
+}
+
+// NewInvitePolicy returns nil if no rule is provided, which leaves the invites unhandled
+func NewInvitePolicy(userIds []string, servers []string, roomPatterns []string) *InvitePolicy {
+	if len(userIds) == 0 && len(servers) == 0 && len(roomPatterns) == 0 {
+		return nil
+	}
+
+	policy := &InvitePolicy{Servers: servers, RoomPatterns: roomPatterns}
+	for _, userId := range userIds {
+		policy.UserIds = append(policy.UserIds, id.UserID(userId))
+	}
+
+	return policy
+}
+
+type InviteDecision struct {
+	RoomId   id.RoomID
+	Inviter  id.UserID
+	Accepted bool
+	Reason   string
+	Time     time.Time
+	Err      error
+}
+
+func (receiver *InvitePolicy) decide(roomId id.RoomID, inviter id.UserID) (accepted bool, reason string) {
+	if slices.Contains(receiver.UserIds, inviter) {
+		return true, InviteReasonAllowedUser
+	}
+	if slices.Contains(receiver.Servers, inviter.Homeserver()) {
+		return true, InviteReasonAllowedServer
+	}
+	for _, pattern := range receiver.RoomPatterns {
+		if matched, _ := path.Match(pattern, string(roomId)); matched {
+			return true, InviteReasonAllowedRoom
+		}
+	}
+
+	return false, InviteReasonNotAllowed
+}
+
+// InviteDecisions returns the decisions about the invites received since the session was opened, oldest first
+func (receiver *Session) InviteDecisions() []InviteDecision {
+	receiver.invitesLock.Lock()
+	defer receiver.invitesLock.Unlock()
+
+	return slices.Clone(receiver.invites)
+}
+
+// handleInvite answers the invite in the background so that the retries don't block the sync loop, an invite
+// which is still being answered is skipped when it shows up in the next sync
+func (receiver *Session) handleInvite(ctx context.Context, evt *event.Event) {
+	if evt.Mautrix.EventSource&event.SourceInvite == 0 || evt.GetStateKey() != receiver.client.UserID.String() {
+		return
+	}
+	if evt.Content.AsMember().Membership != event.MembershipInvite {
+		return
+	}
+
+	receiver.invitesLock.Lock()
+	defer receiver.invitesLock.Unlock()
+
+	if _, ok := receiver.pendingInvites[evt.RoomID]; ok {
+		return
+	}
+	if receiver.pendingInvites == nil {
+		receiver.pendingInvites = make(map[id.RoomID]struct{})
+	}
+	receiver.pendingInvites[evt.RoomID] = struct{}{}
+
+	receiver.invitesWait.Add(1)
+	go func() {
+		defer receiver.invitesWait.Done()
+		receiver.answerInvite(ctx, evt)
+	}()
+}
+
+func (receiver *Session) answerInvite(ctx context.Context, evt *event.Event) {
+	decision := InviteDecision{
+		RoomId:  evt.RoomID,
+		Inviter: evt.Sender,
+		Time:    time.Now(),
+	}
+	decision.Accepted, decision.Reason = receiver.invitePolicy.decide(evt.RoomID, evt.Sender)
+
+	_, decision.Err = retry(ctx, receiver.retryPolicy, func() (err error) {
+		if decision.Accepted {
+			_, err = receiver.client.JoinRoomByID(ctx, evt.RoomID)
+		} else {
+			_, err = receiver.client.LeaveRoom(ctx, evt.RoomID)
+		}
+		return
+	})
+
+	log := receiver.client.Log.Info()
+	if decision.Err != nil {
+		log = receiver.client.Log.Warn().Err(decision.Err)
+	}
+	log.
+		Stringer("room_id", decision.RoomId).
+		Stringer("inviter", decision.Inviter).
+		Bool("accepted", decision.Accepted).
+		Str("reason", decision.Reason).
+		Msg("Handled room invite")
+
+	receiver.invitesLock.Lock()
+	defer receiver.invitesLock.Unlock()
+
+	delete(receiver.pendingInvites, decision.RoomId)
+	receiver.invites = append(receiver.invites, decision)
+	if len(receiver.invites) > maxInviteDecisions {
+		receiver.invites = receiver.invites[len(receiver.invites)-maxInviteDecisions:]
+	}
+}
@@ -0,0 +1,174 @@
+package matrix
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func newInviteEvent(roomId id.RoomID, inviter id.UserID, invitee string) *event.Event {
+	return &event.Event{
+		RoomID:   roomId,
+		Sender:   inviter,
+		Type:     event.StateMember,
+		StateKey: &invitee,
+		Content:  event.Content{Parsed: &event.MemberEventContent{Membership: event.MembershipInvite}},
+		Mautrix:  event.MautrixInfo{EventSource: event.SourceInvite | event.SourceState},
+	}
+}
+
+func TestInvitePolicyDecide(t *testing.T) {
+	policy := &InvitePolicy{
+		UserIds:      []id.UserID{"@admin:example.org"},
+		Servers:      []string{"trusted.org"},
+		RoomPatterns: []string{"!ops*:example.org"},
+	}
+
+	cases := []struct {
+		roomId   id.RoomID
+		inviter  id.UserID
+		accepted bool
+		reason   string
+	}{
+		{"!room:example.org", "@admin:example.org", true, InviteReasonAllowedUser},
+		{"!room:example.org", "@anyone:trusted.org", true, InviteReasonAllowedServer},
+		{"!ops123:example.org", "@stranger:example.org", true, InviteReasonAllowedRoom},
+		{"!room:example.org", "@stranger:example.org", false, InviteReasonNotAllowed},
+		{"!room:example.org", "@admin:evil.org", false, InviteReasonNotAllowed},
+	}
+
+	for _, testCase := range cases {
+		accepted, reason := policy.decide(testCase.roomId, testCase.inviter)
+		if accepted != testCase.accepted || reason != testCase.reason {
+			t.Fatalf("expected %v/%s for %s in %s, got %v/%s", testCase.accepted, testCase.reason, testCase.inviter, testCase.roomId, accepted, reason)
+		}
+	}
+}
+
+func TestHandleInvite(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/join"):
+			writeJSON(t, w, map[string]string{"room_id": "!allowed:example.org"})
+		case strings.HasSuffix(r.URL.Path, "/leave"):
+			writeJSON(t, w, map[string]string{})
+		default:
+			t.Fatalf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	session := &Session{
+		client:       newTestClient(t, server, "@self:example.org"),
+		retryPolicy:  testRetryPolicy,
+		invitePolicy: &InvitePolicy{UserIds: []id.UserID{"@admin:example.org"}},
+	}
+
+	handle := func(evt *event.Event) {
+		session.handleInvite(context.Background(), evt)
+		session.invitesWait.Wait()
+	}
+	handle(newInviteEvent("!allowed:example.org", "@admin:example.org", "@self:example.org"))
+	handle(newInviteEvent("!spam:example.org", "@spammer:example.org", "@self:example.org"))
+	handle(newInviteEvent("!other:example.org", "@admin:example.org", "@someone:example.org"))
+
+	joined := newInviteEvent("!joined:example.org", "@admin:example.org", "@self:example.org")
+	joined.Mautrix.EventSource = event.SourceJoin | event.SourceState
+	handle(joined)
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %v", requests)
+	}
+	if requests[0] != "/_matrix/client/v3/rooms/!allowed:example.org/join" || requests[1] != "/_matrix/client/v3/rooms/!spam:example.org/leave" {
+		t.Fatalf("expected join and leave requests, got %v", requests)
+	}
+
+	decisions := session.InviteDecisions()
+	if len(decisions) != 2 {
+		t.Fatalf("expected 2 decisions, got %d", len(decisions))
+	}
+	if !decisions[0].Accepted || decisions[0].Reason != InviteReasonAllowedUser || decisions[0].Err != nil {
+		t.Fatalf("expected the first invite to be accepted, got %+v", decisions[0])
+	}
+	if decisions[1].Accepted || decisions[1].Reason != InviteReasonNotAllowed || decisions[1].Inviter != "@spammer:example.org" {
+		t.Fatalf("expected the second invite to be rejected, got %+v", decisions[1])
+	}
+}
+
+func TestHandleInviteFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(t, w, map[string]string{"errcode": "M_FORBIDDEN", "error": "not allowed"})
+	}))
+	defer server.Close()
+
+	session := &Session{
+		client:       newTestClient(t, server, "@self:example.org"),
+		retryPolicy:  testRetryPolicy,
+		invitePolicy: &InvitePolicy{Servers: []string{"example.org"}},
+	}
+
+	session.handleInvite(context.Background(), newInviteEvent("!room:example.org", "@admin:example.org", "@self:example.org"))
+	session.invitesWait.Wait()
+
+	decisions := session.InviteDecisions()
+	if len(decisions) != 1 {
+		t.Fatalf("expected 1 decision, got %d", len(decisions))
+	}
+	if !decisions[0].Accepted || Classify(decisions[0].Err).Category != ErrorCategoryForbidden {
+		t.Fatalf("expected an accepted invite with a forbidden error, got %+v", decisions[0])
+	}
+}
+
+func TestHandleInviteDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	var joins atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		joins.Add(1)
+		<-release
+		writeJSON(t, w, map[string]string{"room_id": "!room:example.org"})
+	}))
+	defer server.Close()
+
+	session := &Session{
+		client:       newTestClient(t, server, "@self:example.org"),
+		retryPolicy:  testRetryPolicy,
+		invitePolicy: &InvitePolicy{Servers: []string{"example.org"}},
+	}
+
+	session.handleInvite(context.Background(), newInviteEvent("!room:example.org", "@admin:example.org", "@self:example.org"))
+	session.handleInvite(context.Background(), newInviteEvent("!room:example.org", "@admin:example.org", "@self:example.org"))
+	if decisions := session.InviteDecisions(); len(decisions) != 0 {
+		t.Fatalf("expected the invite to be answered in the background, got %+v", decisions)
+	}
+
+	close(release)
+	session.invitesWait.Wait()
+
+	decisions := session.InviteDecisions()
+	if len(decisions) != 1 || !decisions[0].Accepted || decisions[0].Err != nil {
+		t.Fatalf("expected one accepted invite, got %+v", decisions)
+	}
+	if joins.Load() != 1 {
+		t.Fatalf("expected the pending invite to be joined once, got %d", joins.Load())
+	}
+}
+
+func TestNewInvitePolicy(t *testing.T) {
+	if NewInvitePolicy(nil, nil, nil) != nil {
+		t.Fatalf("expected no policy without rules")
+	}
+
+	policy := NewInvitePolicy([]string{"@admin:example.org"}, nil, []string{"!*:example.org"})
+	if len(policy.UserIds) != 1 || policy.UserIds[0] != "@admin:example.org" || len(policy.RoomPatterns) != 1 {
+		t.Fatalf("expected a user and a room pattern, got %+v", policy)
+	}
+}
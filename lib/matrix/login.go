@@ -8,6 +8,7 @@ import (
 )
 
 func Login(
+	ctx context.Context,
 	homeserver string,
 	username string,
 	password string,
@@ -24,7 +25,7 @@ func Login(
 		return
 	}
 
-	resp, err := client.Login(context.Background(), &mautrix.ReqLogin{
+	resp, err := client.Login(ctx, &mautrix.ReqLogin{
 		Type: mautrix.AuthTypePassword,
 		Identifier: mautrix.UserIdentifier{
 			Type: mautrix.IdentifierTypeUser,
@@ -39,3 +40,28 @@ func Login(
 
 	return resp.DeviceID, resp.AccessToken, nil
 }
+
+func Whoami(
+	ctx context.Context,
+	homeserver string,
+	accessToken string,
+	mautrixFactory MautrixFactory,
+) (userId id.UserID, deviceId id.DeviceID, err error) {
+	if mautrixFactory == nil {
+		mautrixFactory = func() (*mautrix.Client, error) {
+			return mautrix.NewClient(homeserver, "", accessToken)
+		}
+	}
+
+	client, err := mautrixFactory()
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Whoami(ctx)
+	if err != nil {
+		return
+	}
+
+	return resp.UserID, resp.DeviceID, nil
+}
@@ -1,11 +1,14 @@
 package matrix
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix"
@@ -48,7 +51,7 @@ func TestLoginSuccess(t *testing.T) {
 		}, nil
 	}
 
-	deviceID, accessToken, err := Login(homeserver, username, password, factory)
+	deviceID, accessToken, err := Login(context.Background(), homeserver, username, password, factory)
 	if err != nil {
 		t.Fatalf("Login returned error: %v", err)
 	}
@@ -81,7 +84,7 @@ func TestLoginSuccess(t *testing.T) {
 func TestLoginFactoryError(t *testing.T) {
 	expectedErr := errors.New("factory failed")
 
-	_, _, err := Login("https://example.org", "alice", "secret", func() (*mautrix.Client, error) {
+	_, _, err := Login(context.Background(), "https://example.org", "alice", "secret", func() (*mautrix.Client, error) {
 		return nil, expectedErr
 	})
 
@@ -110,8 +113,48 @@ func TestLoginRequestError(t *testing.T) {
 		}, nil
 	}
 
-	_, _, err = Login(homeserver, "alice", "secret", factory)
+	_, _, err = Login(context.Background(), homeserver, "alice", "secret", factory)
 	if !errors.Is(err, requestErr) {
 		t.Fatalf("expected error %v, got %v", requestErr, err)
 	}
 }
+
+func TestLoginTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err := Login(ctx, server.URL, "alice", "secret", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+}
+
+func TestWhoami(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_matrix/client/v3/account/whoami" {
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+		writeJSON(t, w, map[string]string{"user_id": "@bot:example.org", "device_id": "DEVICE"})
+	}))
+	defer server.Close()
+
+	userId, deviceId, err := Whoami(context.Background(), server.URL, "token", func() (*mautrix.Client, error) {
+		return newTestClient(t, server, ""), nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if userId != "@bot:example.org" {
+		t.Fatalf("expected user @bot:example.org, got %s", userId)
+	}
+	if deviceId != "DEVICE" {
+		t.Fatalf("expected device DEVICE, got %s", deviceId)
+	}
+}
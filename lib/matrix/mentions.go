@@ -0,0 +1,87 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrNotRoomMember = errors.New("the mentioned user is not a member of the room")
+
+const roomMention = "@room"
+
+func applyMentions(
+	ctx context.Context,
+	client *mautrix.Client,
+	roomId id.RoomID,
+	content *event.MessageEventContent,
+	options *MessageOptions,
+) error {
+	if options == nil || (len(options.MentionUserIds) == 0 && !options.MentionRoom) {
+		return nil
+	}
+
+	if content.Mentions == nil {
+		content.Mentions = &event.Mentions{}
+	}
+	content.Mentions.Room = options.MentionRoom
+
+	var members map[id.UserID]mautrix.JoinedMember
+	if len(options.MentionUserIds) > 0 {
+		resp, err := client.JoinedMembers(ctx, roomId)
+		if err != nil {
+			return err
+		}
+		members = resp.Joined
+	}
+
+	for _, userId := range options.MentionUserIds {
+		member, ok := members[userId]
+		if !ok {
+			return fmt.Errorf("%w: %s is not joined to %s", ErrNotRoomMember, userId, roomId)
+		}
+		content.Mentions.Add(userId)
+
+		if content.MsgType.IsText() {
+			addUserPill(content, userId, member.DisplayName)
+		}
+	}
+
+	if options.MentionRoom && content.MsgType.IsText() && !strings.Contains(content.Body, roomMention) {
+		content.EnsureHasHTML()
+		content.Body = roomMention + ": " + content.Body
+		content.FormattedBody = roomMention + ": " + content.FormattedBody
+	}
+
+	return nil
+}
+
+// addUserPill replaces the user ID in the message with a pill, or prepends the pill if the user ID is not part of the message
+func addUserPill(content *event.MessageEventContent, userId id.UserID, displayName string) {
+	if displayName == "" {
+		displayName = userId.String()
+	}
+
+	content.EnsureHasHTML()
+	link := userId.URI().MatrixToURL()
+	if strings.Contains(content.FormattedBody, link) {
+		return
+	}
+
+	pill := fmt.Sprintf(`<a href="%s">%s</a>`, link, html.EscapeString(displayName))
+	escapedUserId := html.EscapeString(userId.String())
+
+	if strings.Contains(content.FormattedBody, escapedUserId) {
+		content.FormattedBody = strings.ReplaceAll(content.FormattedBody, escapedUserId, pill)
+		return
+	}
+
+	content.Body = displayName + ": " + content.Body
+	content.FormattedBody = pill + ": " + content.FormattedBody
+}
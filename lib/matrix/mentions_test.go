@@ -0,0 +1,111 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+)
+
+func newMembersServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/joined_members") {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		writeJSON(t, w, map[string]any{
+			"joined": map[string]any{
+				"@oncall:example.com": map[string]string{"display_name": "On Call"},
+				"@self:example.com":   map[string]string{},
+			},
+		})
+	}))
+}
+
+func TestApplyMentionsNoOptions(t *testing.T) {
+	content := format.TextToContent("hello")
+
+	if err := applyMentions(context.Background(), nil, "!room:example.com", &content, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if content.Mentions != nil && (len(content.Mentions.UserIDs) > 0 || content.Mentions.Room) {
+		t.Fatalf("expected no mentions, got %+v", content.Mentions)
+	}
+}
+
+func TestApplyMentionsPrependsPill(t *testing.T) {
+	server := newMembersServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+	content := format.TextToContent("disk full")
+
+	err := applyMentions(context.Background(), client, "!room:example.com", &content, &MessageOptions{
+		MentionUserIds: []id.UserID{"@oncall:example.com"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(content.Mentions.UserIDs) != 1 || content.Mentions.UserIDs[0] != "@oncall:example.com" {
+		t.Fatalf("expected @oncall:example.com to be mentioned, got %+v", content.Mentions)
+	}
+	if content.Mentions.Room {
+		t.Fatalf("expected no room mention")
+	}
+	if content.Body != "On Call: disk full" {
+		t.Fatalf("expected display name prefix in body, got %q", content.Body)
+	}
+	expected := `<a href="https://matrix.to/#/@oncall:example.com">On Call</a>: disk full`
+	if content.Format != event.FormatHTML || content.FormattedBody != expected {
+		t.Fatalf("expected formatted body %q, got %q", expected, content.FormattedBody)
+	}
+}
+
+func TestApplyMentionsReplacesUserId(t *testing.T) {
+	server := newMembersServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+	content := format.RenderMarkdown("ping @oncall:example.com **now**", true, true)
+
+	err := applyMentions(context.Background(), client, "!room:example.com", &content, &MessageOptions{
+		MentionUserIds: []id.UserID{"@oncall:example.com"},
+		MentionRoom:    true,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !content.Mentions.Room {
+		t.Fatalf("expected room mention")
+	}
+	if !strings.Contains(content.FormattedBody, `ping <a href="https://matrix.to/#/@oncall:example.com">On Call</a>`) {
+		t.Fatalf("expected user ID to be replaced with a pill, got %q", content.FormattedBody)
+	}
+	if !strings.HasPrefix(content.Body, "@room: ping @oncall:example.com") {
+		t.Fatalf("expected @room prefix in body, got %q", content.Body)
+	}
+}
+
+func TestApplyMentionsNotMember(t *testing.T) {
+	server := newMembersServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+	content := format.TextToContent("hello")
+
+	err := applyMentions(context.Background(), client, "!room:example.com", &content, &MessageOptions{
+		MentionUserIds: []id.UserID{"@stranger:example.com"},
+	})
+	if !errors.Is(err, ErrNotRoomMember) {
+		t.Fatalf("expected ErrNotRoomMember, got %v", err)
+	}
+}
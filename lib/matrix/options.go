@@ -0,0 +1,27 @@
+package matrix
+
+import (
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+type MessageOptions struct {
+	ThreadRootEventId id.EventID
+	ReplyToEventId    id.EventID
+	MentionUserIds    []id.UserID
+	MentionRoom       bool
+	IdempotencyKey    string
+}
+
+// SplitList splits a comma separated list, the items are trimmed and empty items are skipped
+func SplitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
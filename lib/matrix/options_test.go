@@ -0,0 +1,21 @@
+package matrix
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitList(t *testing.T) {
+	cases := map[string][]string{
+		"":                    nil,
+		" , ,":                nil,
+		"!a:b":                {"!a:b"},
+		" !a:b , ,@c:d,#e:f ": {"!a:b", "@c:d", "#e:f"},
+	}
+
+	for value, expected := range cases {
+		if actual := SplitList(value); !slices.Equal(actual, expected) {
+			t.Fatalf("expected %v for %q, got %v", expected, value, actual)
+		}
+	}
+}
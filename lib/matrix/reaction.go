@@ -0,0 +1,116 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrReactionNotFound = errors.New("no matching reaction from the current user was found")
+
+func reactInRoom(
+	ctx context.Context,
+	client *mautrix.Client,
+	roomId id.RoomID,
+	eventId id.EventID,
+	key string,
+	transactionId string,
+) (*mautrix.RespSendEvent, error) {
+	content := &event.ReactionEventContent{}
+	content.RelatesTo.SetAnnotation(eventId, key)
+
+	// mautrix never encrypts reactions on its own, but clients expect them to be encrypted in encrypted rooms
+	encrypted := false
+	if client.Crypto != nil && client.StateStore != nil {
+		var err error
+		encrypted, err = client.StateStore.IsEncrypted(ctx, roomId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !encrypted {
+		return client.SendMessageEvent(ctx, roomId, event.EventReaction, content, mautrix.ReqSendEvent{TransactionID: transactionId})
+	}
+
+	encryptedContent, err := client.Crypto.Encrypt(ctx, roomId, event.EventReaction, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.SendMessageEvent(ctx, roomId, event.EventEncrypted, encryptedContent, mautrix.ReqSendEvent{TransactionID: transactionId})
+}
+
+// unreactInRoom derives the transaction ID of each redaction from the reaction, so a retry after a lost response
+// doesn't depend on the reactions which are still found
+func unreactInRoom(
+	ctx context.Context,
+	client *mautrix.Client,
+	roomId id.RoomID,
+	eventId id.EventID,
+	key string,
+	transactionId string,
+) (*mautrix.RespSendEvent, error) {
+	reactionIds, err := findOwnReactions(ctx, client, roomId, eventId, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(reactionIds) == 0 {
+		return nil, ErrReactionNotFound
+	}
+
+	var response *mautrix.RespSendEvent
+	for _, reactionId := range reactionIds {
+		response, err = client.RedactEvent(ctx, roomId, reactionId, mautrix.ReqRedact{TxnID: transactionId + "." + string(reactionId)})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+func findOwnReactions(
+	ctx context.Context,
+	client *mautrix.Client,
+	roomId id.RoomID,
+	eventId id.EventID,
+	key string,
+) ([]id.EventID, error) {
+	result := make([]id.EventID, 0)
+	request := &mautrix.ReqGetRelations{
+		RelationType: event.RelAnnotation,
+	}
+
+	for {
+		response, err := client.GetRelations(ctx, roomId, eventId, request)
+		if err != nil {
+			return nil, eventError(err)
+		}
+
+		for _, reaction := range response.Chunk {
+			if reaction.Sender != client.UserID {
+				continue
+			}
+
+			// the relation is kept unencrypted even for encrypted reactions, so it can be read directly
+			var content struct {
+				RelatesTo event.RelatesTo `json:"m.relates_to"`
+			}
+			if err := json.Unmarshal(reaction.Content.VeryRaw, &content); err != nil {
+				continue
+			}
+			if content.RelatesTo.GetAnnotationKey() == key {
+				result = append(result, reaction.ID)
+			}
+		}
+
+		if response.NextBatch == "" {
+			return result, nil
+		}
+		request.From = response.NextBatch
+	}
+}
@@ -0,0 +1,112 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReactInRoom(t *testing.T) {
+	var sent []map[string]any
+	server := newSendServer(t, &sent)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+
+	response, err := reactInRoom(context.Background(), client, "!room:example.com", "$alert", "✅", "txn")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if response.EventID != "$sent" {
+		t.Fatalf("expected event ID $sent, got %s", response.EventID)
+	}
+
+	relatesTo, _ := sent[0]["m.relates_to"].(map[string]any)
+	if relatesTo["rel_type"] != "m.annotation" || relatesTo["event_id"] != "$alert" || relatesTo["key"] != "✅" {
+		t.Fatalf("expected annotation relation, got %v", sent[0]["m.relates_to"])
+	}
+}
+
+func TestUnreactInRoom(t *testing.T) {
+	var redacted []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/relations/"):
+			if !strings.HasSuffix(r.URL.Path, "/m.annotation") {
+				t.Fatalf("expected annotation relations to be requested, got %s", r.URL.Path)
+			}
+			if r.URL.Query().Get("from") == "" {
+				writeJSON(t, w, map[string]any{
+					"chunk": []map[string]any{
+						{
+							"event_id": "$other-user",
+							"sender":   "@other:example.com",
+							"type":     "m.reaction",
+							"content":  map[string]any{"m.relates_to": map[string]string{"rel_type": "m.annotation", "event_id": "$alert", "key": "✅"}},
+						},
+						{
+							"event_id": "$other-key",
+							"sender":   "@self:example.com",
+							"type":     "m.reaction",
+							"content":  map[string]any{"m.relates_to": map[string]string{"rel_type": "m.annotation", "event_id": "$alert", "key": "👀"}},
+						},
+					},
+					"next_batch": "page2",
+				})
+				return
+			}
+			writeJSON(t, w, map[string]any{
+				"chunk": []map[string]any{
+					{
+						"event_id": "$own",
+						"sender":   "@self:example.com",
+						"type":     "m.room.encrypted",
+						"content": map[string]any{
+							"algorithm":    "m.megolm.v1.aes-sha2",
+							"ciphertext":   "opaque",
+							"m.relates_to": map[string]string{"rel_type": "m.annotation", "event_id": "$alert", "key": "✅"},
+						},
+					},
+				},
+			})
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/redact/"):
+			parts := strings.Split(r.URL.Path, "/")
+			redacted = append(redacted, parts[len(parts)-2])
+			writeJSON(t, w, map[string]string{"event_id": "$redaction"})
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+
+	response, err := unreactInRoom(context.Background(), client, "!room:example.com", "$alert", "✅", "txn")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if response.EventID != "$redaction" {
+		t.Fatalf("expected redaction event ID, got %s", response.EventID)
+	}
+	if len(redacted) != 1 || redacted[0] != "$own" {
+		t.Fatalf("expected only $own to be redacted, got %v", redacted)
+	}
+}
+
+func TestUnreactInRoomNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]any{"chunk": []any{}})
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+
+	_, err := unreactInRoom(context.Background(), client, "!room:example.com", "$alert", "✅", "txn")
+	if !errors.Is(err, ErrReactionNotFound) {
+		t.Fatalf("expected ErrReactionNotFound, got %v", err)
+	}
+}
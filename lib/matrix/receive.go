@@ -0,0 +1,131 @@
+package matrix
+
+import (
+	"context"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// the oldest messages are dropped if the host doesn't fetch them in time
+const maxIncomingMessages = 1000
+
+type IncomingMessage struct {
+	Sequence          uint64
+	EventId           id.EventID
+	RoomId            id.RoomID
+	Sender            id.UserID
+	SenderDevice      id.DeviceID
+	Timestamp         time.Time
+	MessageType       event.MessageType
+	Body              string
+	FormattedBody     string
+	ThreadRootEventId id.EventID
+	ReplyToEventId    id.EventID
+	ReplacesEventId   id.EventID
+	Encrypted         bool
+	Verification      id.TrustState
+}
+
+// FetchEvents returns the received messages with a sequence greater than since, ordered by the sequence
+func (receiver *Session) FetchEvents(since uint64) []IncomingMessage {
+	receiver.incomingLock.Lock()
+	defer receiver.incomingLock.Unlock()
+
+	var messages []IncomingMessage
+	for _, message := range receiver.incoming {
+		if message.Sequence > since {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
+
+func (receiver *Session) receiveMessage(ctx context.Context, evt *event.Event) {
+	if evt.Sender == receiver.client.UserID {
+		return
+	}
+
+	message, ok := newIncomingMessage(evt)
+	if !ok {
+		return
+	}
+	message.Verification = receiver.senderTrust(ctx, evt)
+
+	receiver.incomingLock.Lock()
+	defer receiver.incomingLock.Unlock()
+
+	receiver.lastSequence++
+	message.Sequence = receiver.lastSequence
+	receiver.incoming = append(receiver.incoming, message)
+	if len(receiver.incoming) > maxIncomingMessages {
+		receiver.incoming = receiver.incoming[len(receiver.incoming)-maxIncomingMessages:]
+	}
+}
+
+// senderTrust resolves the trust of the sender device like the trust of the devices the room keys are shared with,
+// the olm machine reports cross-signed devices of unverified users as verified
+func (receiver *Session) senderTrust(ctx context.Context, evt *event.Event) id.TrustState {
+	if receiver.trust == nil || evt.Mautrix.TrustSource == nil {
+		return evt.Mautrix.TrustState
+	}
+
+	trust, err := receiver.trust.deviceTrust(ctx, evt.Mautrix.TrustSource)
+	if err != nil {
+		receiver.client.Log.Warn().Err(err).Stringer("event_id", evt.ID).Msg("Failed to resolve the trust of the sender device")
+		return id.TrustStateUnset
+	}
+
+	// a lower trust reported by the olm machine, e.g. for forwarded room keys, is kept
+	return min(trust, evt.Mautrix.TrustState)
+}
+
+func newIncomingMessage(evt *event.Event) (message IncomingMessage, ok bool) {
+	content := evt.Content.AsMessage()
+	if content.MsgType == "" {
+		return
+	}
+
+	message = IncomingMessage{
+		EventId:       evt.ID,
+		RoomId:        evt.RoomID,
+		Sender:        evt.Sender,
+		Timestamp:     time.UnixMilli(evt.Timestamp),
+		MessageType:   content.MsgType,
+		Body:          content.Body,
+		FormattedBody: content.FormattedBody,
+		Encrypted:     evt.Mautrix.WasEncrypted,
+		Verification:  evt.Mautrix.TrustState,
+	}
+	if evt.Mautrix.TrustSource != nil {
+		message.SenderDevice = evt.Mautrix.TrustSource.DeviceID
+	}
+	if content.NewContent != nil {
+		message.Body = content.NewContent.Body
+		message.FormattedBody = content.NewContent.FormattedBody
+	}
+	if content.RelatesTo != nil {
+		message.ThreadRootEventId = content.RelatesTo.GetThreadParent()
+		message.ReplyToEventId = content.RelatesTo.GetNonFallbackReplyTo()
+		message.ReplacesEventId = content.RelatesTo.GetReplaceID()
+	}
+
+	return message, true
+}
+
+// dropHistory removes the timeline of the initial sync, so that only the messages sent after the device
+// started syncing are received
+func dropHistory(_ context.Context, resp *mautrix.RespSync, since string) bool {
+	if since != "" {
+		return true
+	}
+
+	for _, room := range resp.Rooms.Join {
+		room.Timeline.Events = nil
+	}
+
+	return true
+}
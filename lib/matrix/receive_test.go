@@ -0,0 +1,165 @@
+package matrix
+
+import (
+	"context"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func newMessageEvent(sender id.UserID, content *event.MessageEventContent) *event.Event {
+	evt := &event.Event{
+		ID:        "$event",
+		RoomID:    "!room:example.org",
+		Sender:    sender,
+		Type:      event.EventMessage,
+		Timestamp: 1700000000000,
+		Content:   event.Content{Parsed: content},
+	}
+
+	return evt
+}
+
+func TestReceiveMessage(t *testing.T) {
+	session := &Session{client: &mautrix.Client{UserID: "@self:example.org"}}
+
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: "!ack 1234"}
+	content.RelatesTo = (&event.RelatesTo{}).SetThread("$root", "$previous")
+	evt := newMessageEvent("@alice:example.org", content)
+	evt.Mautrix.WasEncrypted = true
+	evt.Mautrix.TrustState = id.TrustStateCrossSignedVerified
+	evt.Mautrix.TrustSource = &id.Device{DeviceID: "ALICE"}
+
+	session.receiveMessage(context.Background(), newMessageEvent("@self:example.org", &event.MessageEventContent{MsgType: event.MsgText, Body: "own"}))
+	session.receiveMessage(context.Background(), evt)
+
+	messages := session.FetchEvents(0)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+
+	message := messages[0]
+	if message.Sequence != 1 {
+		t.Fatalf("expected sequence 1, got %d", message.Sequence)
+	}
+	if message.Body != "!ack 1234" || message.Sender != "@alice:example.org" || message.RoomId != "!room:example.org" {
+		t.Fatalf("unexpected message %+v", message)
+	}
+	if message.ThreadRootEventId != "$root" {
+		t.Fatalf("expected thread root $root, got %s", message.ThreadRootEventId)
+	}
+	if message.ReplyToEventId != "" {
+		t.Fatalf("expected no reply for a thread fallback, got %s", message.ReplyToEventId)
+	}
+	if !message.Encrypted || message.Verification != id.TrustStateCrossSignedVerified || message.SenderDevice != "ALICE" {
+		t.Fatalf("unexpected verification status %+v", message)
+	}
+
+	if messages := session.FetchEvents(1); len(messages) != 0 {
+		t.Fatalf("expected no messages after sequence 1, got %d", len(messages))
+	}
+}
+
+func TestReceiveMessageEdit(t *testing.T) {
+	session := &Session{client: &mautrix.Client{UserID: "@self:example.org"}}
+
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: "* fixed"}
+	content.SetEdit("$original")
+	content.NewContent = &event.MessageEventContent{MsgType: event.MsgText, Body: "fixed"}
+
+	session.receiveMessage(context.Background(), newMessageEvent("@alice:example.org", content))
+
+	messages := session.FetchEvents(0)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+	if messages[0].Body != "fixed" || messages[0].ReplacesEventId != "$original" {
+		t.Fatalf("expected the new content of $original, got %+v", messages[0])
+	}
+}
+
+func TestReceiveMessageLimit(t *testing.T) {
+	session := &Session{client: &mautrix.Client{UserID: "@self:example.org"}}
+
+	for range maxIncomingMessages + 5 {
+		session.receiveMessage(context.Background(), newMessageEvent("@alice:example.org", &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"}))
+	}
+
+	messages := session.FetchEvents(0)
+	if len(messages) != maxIncomingMessages {
+		t.Fatalf("expected %d messages, got %d", maxIncomingMessages, len(messages))
+	}
+	if messages[0].Sequence != 6 {
+		t.Fatalf("expected the oldest messages to be dropped, first sequence is %d", messages[0].Sequence)
+	}
+}
+
+func TestDropHistory(t *testing.T) {
+	newResponse := func() *mautrix.RespSync {
+		resp := &mautrix.RespSync{}
+		resp.Rooms.Join = map[id.RoomID]*mautrix.SyncJoinedRoom{
+			"!room:example.org": {Timeline: mautrix.SyncTimeline{SyncEventsList: mautrix.SyncEventsList{Events: []*event.Event{{}}}}},
+		}
+		return resp
+	}
+
+	resp := newResponse()
+	if !dropHistory(context.Background(), resp, "") {
+		t.Fatalf("expected the sync to be processed")
+	}
+	if len(resp.Rooms.Join["!room:example.org"].Timeline.Events) != 0 {
+		t.Fatalf("expected the initial timeline to be dropped")
+	}
+
+	resp = newResponse()
+	dropHistory(context.Background(), resp, "batch")
+	if len(resp.Rooms.Join["!room:example.org"].Timeline.Events) != 1 {
+		t.Fatalf("expected the timeline to be kept")
+	}
+}
+
+func TestReceiveMessageSenderTrust(t *testing.T) {
+	ctx := context.Background()
+	trust := newTestTrustStore(t, id.TrustStateUnset)
+	session := &Session{client: &mautrix.Client{UserID: trustOwnUser}, trust: trust}
+
+	cases := []struct {
+		userId   id.UserID
+		deviceId id.DeviceID
+		reported id.TrustState
+		expected id.TrustState
+	}{
+		{trustVerified, "ALICE", id.TrustStateCrossSignedVerified, id.TrustStateCrossSignedVerified},
+		{trustFirstSeen, "BOB", id.TrustStateCrossSignedVerified, id.TrustStateCrossSignedTOFU},
+		{trustChanged, "CAROL", id.TrustStateCrossSignedVerified, id.TrustStateUnset},
+		{trustVerified, "ALICE", id.TrustStateForwarded, id.TrustStateForwarded},
+	}
+
+	for _, testCase := range cases {
+		device, err := trust.Store.GetDevice(ctx, testCase.userId, testCase.deviceId)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		evt := newMessageEvent(testCase.userId, &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"})
+		evt.Mautrix.WasEncrypted = true
+		evt.Mautrix.TrustState = testCase.reported
+		evt.Mautrix.TrustSource = device
+
+		if actual := session.senderTrust(ctx, evt); actual != testCase.expected {
+			t.Fatalf("expected %s for %s reported as %s, got %s", testCase.expected, testCase.deviceId, testCase.reported, actual)
+		}
+	}
+
+	evt := newMessageEvent(trustFirstSeen, &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"})
+	evt.Mautrix.TrustState = id.TrustStateCrossSignedVerified
+	evt.Mautrix.TrustSource, _ = trust.Store.GetDevice(ctx, trustFirstSeen, "BOB")
+	session.receiveMessage(ctx, evt)
+
+	messages := session.FetchEvents(0)
+	if len(messages) != 1 || messages[0].Verification != id.TrustStateCrossSignedTOFU {
+		t.Fatalf("expected the cross-signed device of the unverified user not to be verified, got %+v", messages)
+	}
+}
@@ -11,7 +11,13 @@ import (
 	"maunium.net/go/mautrix/id"
 )
 
-func resolveRecipient(client *mautrix.Client, recipient string) (id.RoomID, error) {
+// resolveRecipient retries the lookups according to the retry policy, creating a direct message room is only
+// retried when it was rate limited, a lost response to any other failed attempt could have created the room already
+func resolveRecipient(ctx context.Context, client *mautrix.Client, recipient string, policy RetryPolicy) (id.RoomID, error) {
+	if recipient == "" {
+		return "", newError(ErrorCategoryInvalidRecipient, errors.New("the recipient is empty"))
+	}
+
 	first := recipient[0]
 
 	if first == '!' {
@@ -19,24 +25,30 @@ func resolveRecipient(client *mautrix.Client, recipient string) (id.RoomID, erro
 	}
 
 	if first == '@' {
-		return resolveDirectMessageRecipient(client, recipient)
+		return resolveDirectMessageRecipient(ctx, client, recipient, policy)
 	}
 
 	if first == '#' {
-		return resolveRoomAliasRecipient(client, recipient)
+		return resolveRoomAliasRecipient(ctx, client, recipient, policy)
 	}
 
-	return "", errors.New("unknown recipient: " + recipient)
+	return "", newError(ErrorCategoryInvalidRecipient, errors.New("unknown recipient: "+recipient))
 }
 
-func resolveDirectMessageRecipient(client *mautrix.Client, recipient string) (id.RoomID, error) {
+func resolveDirectMessageRecipient(ctx context.Context, client *mautrix.Client, recipient string, policy RetryPolicy) (id.RoomID, error) {
 	var out map[string][]id.RoomID
-	err := client.GetAccountData(context.Background(), "m.direct", &out)
+	_, err := retry(ctx, policy, func() error {
+		return client.GetAccountData(ctx, "m.direct", &out)
+	})
 	if err != nil {
 		return "", err
 	}
 
-	joinedRoomsResp, err := client.JoinedRooms(context.Background())
+	var joinedRoomsResp *mautrix.RespJoinedRooms
+	_, err = retry(ctx, policy, func() (err error) {
+		joinedRoomsResp, err = client.JoinedRooms(ctx)
+		return
+	})
 	if err != nil {
 		return "", err
 	}
@@ -47,7 +59,7 @@ func resolveDirectMessageRecipient(client *mautrix.Client, recipient string) (id
 		}
 	}
 
-	respCreate, err := client.CreateRoom(context.Background(), &mautrix.ReqCreateRoom{
+	request := &mautrix.ReqCreateRoom{
 		Preset:   "trusted_private_chat",
 		IsDirect: true,
 		Invite: []id.UserID{
@@ -64,19 +76,28 @@ func resolveDirectMessageRecipient(client *mautrix.Client, recipient string) (id
 				},
 			},
 		},
+	}
+	var respCreate *mautrix.RespCreateRoom
+	_, err = retryRateLimited(ctx, policy, func() (err error) {
+		respCreate, err = client.CreateRoom(ctx, request)
+		return
 	})
 	if err != nil {
 		return "", err
 	}
 
 	out[recipient] = append(out[recipient], respCreate.RoomID)
-	_ = client.SetAccountData(context.Background(), "m.direct", out)
+	_ = client.SetAccountData(ctx, "m.direct", out)
 
 	return respCreate.RoomID, nil
 }
 
-func resolveRoomAliasRecipient(client *mautrix.Client, recipient string) (id.RoomID, error) {
-	resp, err := client.ResolveAlias(context.Background(), id.RoomAlias(recipient))
+func resolveRoomAliasRecipient(ctx context.Context, client *mautrix.Client, recipient string, policy RetryPolicy) (id.RoomID, error) {
+	var resp *mautrix.RespAliasResolve
+	_, err := retry(ctx, policy, func() (err error) {
+		resp, err = client.ResolveAlias(ctx, id.RoomAlias(recipient))
+		return
+	})
 	if err != nil {
 		return "", err
 	}
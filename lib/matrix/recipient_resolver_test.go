@@ -1,6 +1,7 @@
 package matrix
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -60,7 +61,7 @@ func TestResolveRecipientDirectMessageExistingRoom(t *testing.T) {
 
 	client := newTestClient(t, server, "@self:example.com")
 
-	roomID, err := resolveRecipient(client, "@friend:example.com")
+	roomID, err := resolveRecipient(context.Background(), client, "@friend:example.com", testRetryPolicy)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -111,7 +112,7 @@ func TestResolveRecipientDirectMessageCreatesRoom(t *testing.T) {
 
 	client := newTestClient(t, server, "@self:example.com")
 
-	roomID, err := resolveRecipient(client, "@friend:example.com")
+	roomID, err := resolveRecipient(context.Background(), client, "@friend:example.com", testRetryPolicy)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -154,7 +155,7 @@ func TestResolveRecipientRoomAlias(t *testing.T) {
 
 	client := newTestClient(t, server, "@self:example.com")
 
-	roomID, err := resolveRecipient(client, "#general:example.com")
+	roomID, err := resolveRecipient(context.Background(), client, "#general:example.com", testRetryPolicy)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -164,7 +165,7 @@ func TestResolveRecipientRoomAlias(t *testing.T) {
 }
 
 func TestResolveRecipientRoomID(t *testing.T) {
-	roomID, err := resolveRecipient(nil, "!room:example.com")
+	roomID, err := resolveRecipient(context.Background(), nil, "!room:example.com", testRetryPolicy)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -174,7 +175,7 @@ func TestResolveRecipientRoomID(t *testing.T) {
 }
 
 func TestResolveRecipientUnknown(t *testing.T) {
-	_, err := resolveRecipient(nil, "unknown")
+	_, err := resolveRecipient(context.Background(), nil, "unknown", testRetryPolicy)
 	if err == nil {
 		t.Fatalf("expected error for unknown recipient")
 	}
@@ -188,3 +189,56 @@ func writeJSON(t *testing.T, w http.ResponseWriter, payload any) {
 		t.Fatalf("failed to encode payload: %v", err)
 	}
 }
+
+func TestResolveRecipientDirectMessageRetriesCreateRoomOnlyWhenRateLimited(t *testing.T) {
+	cases := []struct {
+		name          string
+		status        int
+		expectedCalls int
+		expectError   bool
+	}{
+		{"server error", http.StatusBadGateway, 1, true},
+		{"rate limited", http.StatusTooManyRequests, 2, false},
+	}
+
+	for _, testCase := range cases {
+		createRoomCalls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/account_data/m.direct"):
+				writeJSON(t, w, map[string][]string{})
+			case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/joined_rooms"):
+				writeJSON(t, w, map[string][]string{"joined_rooms": {}})
+			case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/createRoom"):
+				createRoomCalls++
+				if createRoomCalls > 1 {
+					writeJSON(t, w, map[string]string{"room_id": "!new:example.com"})
+					return
+				}
+				w.WriteHeader(testCase.status)
+				if testCase.status == http.StatusTooManyRequests {
+					writeJSON(t, w, map[string]any{"errcode": "M_LIMIT_EXCEEDED", "error": "Slow down", "retry_after_ms": 1})
+					return
+				}
+				writeJSON(t, w, map[string]string{"errcode": "M_UNKNOWN", "error": "Bad gateway"})
+			case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/account_data/m.direct"):
+				writeJSON(t, w, map[string]string{})
+			default:
+				t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+			}
+		}))
+
+		roomId, err := resolveRecipient(context.Background(), newTestClient(t, server, "@self:example.com"), "@friend:example.com", testRetryPolicy)
+		server.Close()
+
+		if (err != nil) != testCase.expectError {
+			t.Fatalf("%s: expected error %t, got %v", testCase.name, testCase.expectError, err)
+		}
+		if !testCase.expectError && roomId != "!new:example.com" {
+			t.Fatalf("%s: expected !new:example.com, got %s", testCase.name, roomId)
+		}
+		if createRoomCalls != testCase.expectedCalls {
+			t.Fatalf("%s: expected create room to be called %d times, got %d", testCase.name, testCase.expectedCalls, createRoomCalls)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type PowerLevelError struct {
+	Action        string
+	RoomId        id.RoomID
+	UserId        id.UserID
+	RequiredLevel int
+	UserLevel     int
+	Err           error
+}
+
+func (receiver *PowerLevelError) Error() string {
+	if receiver.Err != nil {
+		return fmt.Sprintf("insufficient power level to %s in %s: %v", receiver.Action, receiver.RoomId, receiver.Err)
+	}
+
+	return fmt.Sprintf(
+		"insufficient power level to %s in %s: %s has power level %d, %d is required",
+		receiver.Action,
+		receiver.RoomId,
+		receiver.UserId,
+		receiver.UserLevel,
+		receiver.RequiredLevel,
+	)
+}
+
+func (receiver *PowerLevelError) Unwrap() error {
+	return receiver.Err
+}
+
+func redactInRoom(
+	ctx context.Context,
+	client *mautrix.Client,
+	roomId id.RoomID,
+	eventId id.EventID,
+	reason string,
+	transactionId string,
+) (*mautrix.RespSendEvent, error) {
+	err := checkRedactionPowerLevel(ctx, client, roomId, eventId)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.RedactEvent(ctx, roomId, eventId, mautrix.ReqRedact{Reason: reason, TxnID: transactionId})
+	err = eventError(err)
+	if errors.Is(err, mautrix.MForbidden) {
+		return nil, &PowerLevelError{
+			Action: "redact",
+			RoomId: roomId,
+			UserId: client.UserID,
+			Err:    err,
+		}
+	}
+
+	return response, err
+}
+
+func checkRedactionPowerLevel(ctx context.Context, client *mautrix.Client, roomId id.RoomID, eventId id.EventID) error {
+	var powerLevels event.PowerLevelsEventContent
+	err := client.StateEvent(ctx, roomId, event.StatePowerLevels, "", &powerLevels)
+	if err != nil {
+		return err
+	}
+
+	original, err := client.GetEvent(ctx, roomId, eventId)
+	if err != nil {
+		return eventError(err)
+	}
+
+	requiredLevel := powerLevels.GetEventLevel(event.EventRedaction)
+	if original.Sender != client.UserID {
+		requiredLevel = max(requiredLevel, powerLevels.Redact())
+	}
+
+	userLevel := powerLevels.GetUserLevel(client.UserID)
+	if userLevel < requiredLevel {
+		return &PowerLevelError{
+			Action:        "redact",
+			RoomId:        roomId,
+			UserId:        client.UserID,
+			RequiredLevel: requiredLevel,
+			UserLevel:     userLevel,
+		}
+	}
+
+	return nil
+}
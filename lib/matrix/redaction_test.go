@@ -0,0 +1,125 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newRedactionServer(t *testing.T, sender string, userLevel int, redactCalls *int) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/state/m.room.power_levels/"):
+			writeJSON(t, w, map[string]any{
+				"redact": 50,
+				"users": map[string]int{
+					"@self:example.com": userLevel,
+				},
+			})
+		case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/event/"):
+			writeJSON(t, w, map[string]any{
+				"event_id": "$alert",
+				"sender":   sender,
+				"type":     "m.room.encrypted",
+				"content":  map[string]any{},
+			})
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/redact/"):
+			*redactCalls++
+			writeJSON(t, w, map[string]string{"event_id": "$redaction"})
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+func TestRedactOwnMessage(t *testing.T) {
+	redactCalls := 0
+	server := newRedactionServer(t, "@self:example.com", 0, &redactCalls)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+
+	response, err := redactInRoom(context.Background(), client, "!room:example.com", "$alert", "sensitive data", "txn")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if response.EventID != "$redaction" {
+		t.Fatalf("expected redaction event ID, got %s", response.EventID)
+	}
+	if redactCalls != 1 {
+		t.Fatalf("expected 1 redact call, got %d", redactCalls)
+	}
+}
+
+func TestRedactOtherMessageWithoutPowerLevel(t *testing.T) {
+	redactCalls := 0
+	server := newRedactionServer(t, "@other:example.com", 10, &redactCalls)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+
+	_, err := redactInRoom(context.Background(), client, "!room:example.com", "$alert", "", "txn")
+
+	var powerLevelErr *PowerLevelError
+	if !errors.As(err, &powerLevelErr) {
+		t.Fatalf("expected PowerLevelError, got %v", err)
+	}
+	if powerLevelErr.RequiredLevel != 50 || powerLevelErr.UserLevel != 10 {
+		t.Fatalf("expected required level 50 and user level 10, got %+v", powerLevelErr)
+	}
+	if redactCalls != 0 {
+		t.Fatalf("expected no redact call, got %d", redactCalls)
+	}
+}
+
+func TestRedactForbiddenByServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/state/m.room.power_levels/"):
+			writeJSON(t, w, map[string]any{"users": map[string]int{"@self:example.com": 100}})
+		case strings.Contains(r.URL.Path, "/event/"):
+			writeJSON(t, w, map[string]any{"event_id": "$alert", "sender": "@other:example.com", "type": "m.room.message"})
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			writeJSON(t, w, map[string]string{"errcode": "M_FORBIDDEN", "error": "You cannot redact this event"})
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+
+	_, err := redactInRoom(context.Background(), client, "!room:example.com", "$alert", "", "txn")
+
+	var powerLevelErr *PowerLevelError
+	if !errors.As(err, &powerLevelErr) {
+		t.Fatalf("expected PowerLevelError, got %v", err)
+	}
+	if powerLevelErr.Err == nil {
+		t.Fatalf("expected the server error to be wrapped")
+	}
+}
+
+func TestRedactMissingEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/state/m.room.power_levels/"):
+			writeJSON(t, w, map[string]any{"users": map[string]int{"@self:example.com": 100}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(t, w, map[string]string{"errcode": "M_NOT_FOUND", "error": "Event not found"})
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+
+	_, err := redactInRoom(context.Background(), client, "!room:example.com", "$missing", "", "txn")
+	if category := Classify(err).Category; category != ErrorCategoryEventNotFound {
+		t.Fatalf("expected event_not_found category, got %s", category)
+	}
+}
@@ -0,0 +1,102 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func applyRelations(
+	ctx context.Context,
+	client *mautrix.Client,
+	roomId id.RoomID,
+	content *event.MessageEventContent,
+	options *MessageOptions,
+) error {
+	if options == nil {
+		return nil
+	}
+
+	if options.ReplyToEventId != "" {
+		original, err := fetchEvent(ctx, client, roomId, options.ReplyToEventId)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the event to reply to: %w", err)
+		}
+
+		if content.MsgType.IsText() {
+			addReplyFallback(content, original)
+		}
+		content.SetReply(original)
+	}
+
+	if options.ThreadRootEventId != "" {
+		content.GetRelatesTo().SetThread(options.ThreadRootEventId, options.ThreadRootEventId)
+	}
+
+	return nil
+}
+
+func fetchEvent(ctx context.Context, client *mautrix.Client, roomId id.RoomID, eventId id.EventID) (*event.Event, error) {
+	evt, err := client.GetEvent(ctx, roomId, eventId)
+	if err != nil {
+		return nil, eventError(err)
+	}
+	evt.RoomID = roomId
+
+	if evt.Type == event.EventEncrypted && client.Crypto != nil {
+		err = evt.Content.ParseRaw(evt.Type)
+		if err != nil {
+			return nil, err
+		}
+		decrypted, err := client.Crypto.Decrypt(ctx, evt)
+		if err != nil {
+			// the event can still be referenced, it just won't have a fallback body
+			return evt, nil
+		}
+		evt = decrypted
+	}
+
+	if evt.Content.Parsed == nil {
+		_ = evt.Content.ParseRaw(evt.Type)
+	}
+
+	return evt, nil
+}
+
+func addReplyFallback(content *event.MessageEventContent, original *event.Event) {
+	originalContent, ok := original.Content.Parsed.(*event.MessageEventContent)
+	if !ok {
+		return
+	}
+	originalContent.RemoveReplyFallback()
+
+	originalHtml := originalContent.FormattedBody
+	if originalContent.Format != event.FormatHTML || originalHtml == "" {
+		originalHtml = event.TextToHTML(originalContent.Body)
+	}
+
+	var quoted strings.Builder
+	for index, line := range strings.Split(originalContent.Body, "\n") {
+		if index == 0 {
+			quoted.WriteString(fmt.Sprintf("> <%s> %s\n", original.Sender, line))
+		} else {
+			quoted.WriteString(fmt.Sprintf("> %s\n", line))
+		}
+	}
+
+	content.EnsureHasHTML()
+	content.Body = quoted.String() + "\n" + content.Body
+	content.FormattedBody = fmt.Sprintf(
+		`<mx-reply><blockquote><a href="%s">In reply to</a> <a href="%s">%s</a><br>%s</blockquote></mx-reply>%s`,
+		original.RoomID.EventURI(original.ID).MatrixToURL(),
+		original.Sender.URI().MatrixToURL(),
+		html.EscapeString(original.Sender.String()),
+		originalHtml,
+		content.FormattedBody,
+	)
+}
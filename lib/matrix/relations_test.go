@@ -0,0 +1,126 @@
+package matrix
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+)
+
+func newEventServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.Contains(r.URL.Path, "/event/") {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		writeJSON(t, w, map[string]any{
+			"event_id": "$original",
+			"room_id":  "!room:example.com",
+			"sender":   "@alert:example.com",
+			"type":     "m.room.message",
+			"content": map[string]string{
+				"msgtype": "m.text",
+				"body":    "Disk full\non db1",
+			},
+		})
+	}))
+}
+
+func TestApplyRelationsNoOptions(t *testing.T) {
+	content := format.TextToContent("hello")
+
+	if err := applyRelations(context.Background(), nil, "!room:example.com", &content, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if content.RelatesTo != nil {
+		t.Fatalf("expected no relation, got %+v", content.RelatesTo)
+	}
+}
+
+func TestApplyRelationsThread(t *testing.T) {
+	content := format.TextToContent("acknowledged")
+
+	err := applyRelations(context.Background(), nil, "!room:example.com", &content, &MessageOptions{
+		ThreadRootEventId: "$root",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if content.RelatesTo.Type != event.RelThread || content.RelatesTo.EventID != "$root" {
+		t.Fatalf("expected thread relation to $root, got %+v", content.RelatesTo)
+	}
+	if content.RelatesTo.GetReplyTo() != "$root" || !content.RelatesTo.IsFallingBack {
+		t.Fatalf("expected falling back reply to $root, got %+v", content.RelatesTo)
+	}
+	if content.Body != "acknowledged" {
+		t.Fatalf("expected body without reply fallback, got %q", content.Body)
+	}
+}
+
+func TestApplyRelationsReply(t *testing.T) {
+	server := newEventServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+	content := format.TextToContent("resolved")
+
+	err := applyRelations(context.Background(), client, "!room:example.com", &content, &MessageOptions{
+		ReplyToEventId: "$original",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if content.RelatesTo.Type != "" || content.RelatesTo.GetReplyTo() != "$original" {
+		t.Fatalf("expected reply relation to $original, got %+v", content.RelatesTo)
+	}
+	if !content.Mentions.Has("@alert:example.com") {
+		t.Fatalf("expected original sender to be mentioned")
+	}
+
+	expectedBody := "> <@alert:example.com> Disk full\n> on db1\n\nresolved"
+	if content.Body != expectedBody {
+		t.Fatalf("expected body %q, got %q", expectedBody, content.Body)
+	}
+	if content.Format != event.FormatHTML {
+		t.Fatalf("expected html format, got %q", content.Format)
+	}
+	if !strings.HasPrefix(content.FormattedBody, "<mx-reply><blockquote>") ||
+		!strings.Contains(content.FormattedBody, "https://matrix.to/#/%21room:example.com/$original") ||
+		!strings.Contains(content.FormattedBody, "Disk full<br/>on db1") ||
+		!strings.HasSuffix(content.FormattedBody, "</mx-reply>resolved") {
+		t.Fatalf("unexpected formatted body: %s", content.FormattedBody)
+	}
+}
+
+func TestApplyRelationsThreadedReply(t *testing.T) {
+	server := newEventServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+	content := event.MessageEventContent{MsgType: event.MsgNotice, Body: "resolved"}
+
+	err := applyRelations(context.Background(), client, "!room:example.com", &content, &MessageOptions{
+		ThreadRootEventId: "$root",
+		ReplyToEventId:    "$original",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if content.RelatesTo.Type != event.RelThread || content.RelatesTo.EventID != "$root" {
+		t.Fatalf("expected thread relation to $root, got %+v", content.RelatesTo)
+	}
+	if content.RelatesTo.GetReplyTo() != "$original" || content.RelatesTo.IsFallingBack {
+		t.Fatalf("expected real reply to $original, got %+v", content.RelatesTo)
+	}
+	if !strings.HasPrefix(content.Body, "> <@alert:example.com> Disk full") {
+		t.Fatalf("expected reply fallback in notice body, got %q", content.Body)
+	}
+}
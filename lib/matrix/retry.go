@@ -0,0 +1,74 @@
+package matrix
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Deadline       time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Deadline:       2 * time.Minute,
+}
+
+// retry calls the operation until it succeeds, fails with an error that is not retryable or the policy is exhausted,
+// the rate limit delay requested by the server takes precedence over the exponential backoff. The deadline of
+// the context is used if it has one, so chained retries share it instead of each getting the whole policy deadline
+func retry(ctx context.Context, policy RetryPolicy, operation func() error) (attempts int, err error) {
+	return retryWhen(ctx, policy, (*Error).Retryable, operation)
+}
+
+// retryRateLimited only retries the rate limited attempts, the server didn't process those, so it's safe for
+// operations which must not run twice
+func retryRateLimited(ctx context.Context, policy RetryPolicy, operation func() error) (attempts int, err error) {
+	return retryWhen(ctx, policy, func(err *Error) bool {
+		return err.Category == ErrorCategoryRateLimited
+	}, operation)
+}
+
+func retryWhen(ctx context.Context, policy RetryPolicy, retryable func(*Error) bool, operation func() error) (attempts int, err error) {
+	deadline, hasDeadline := ctx.Deadline()
+	if !hasDeadline && policy.Deadline > 0 {
+		deadline, hasDeadline = time.Now().Add(policy.Deadline), true
+	}
+	backoff := policy.InitialBackoff
+
+	for {
+		attempts++
+		err = operation()
+		if err == nil || attempts >= policy.MaxAttempts {
+			return
+		}
+
+		classified := Classify(err)
+		if !retryable(classified) {
+			return
+		}
+
+		wait := classified.RetryAfter
+		if wait <= 0 {
+			wait = backoff/2 + rand.N(backoff/2+1)
+			backoff = min(backoff*2, policy.MaxBackoff)
+		}
+		if hasDeadline && time.Now().Add(wait).After(deadline) {
+			return
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Deadline:       time.Second,
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name             string
+		responses        []int
+		expectedAttempts int
+		expectError      bool
+	}{
+		{"success", []int{http.StatusOK}, 1, false},
+		{"rate limited", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false},
+		{"server error", []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, 3, false},
+		{"exhausted", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK}, 3, true},
+		{"permanent", []int{http.StatusForbidden, http.StatusOK}, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := test.responses[requests]
+				requests++
+
+				switch status {
+				case http.StatusOK:
+					writeJSON(t, w, map[string]any{"joined": map[string]any{}})
+				case http.StatusTooManyRequests:
+					w.WriteHeader(status)
+					writeJSON(t, w, map[string]any{"errcode": "M_LIMIT_EXCEEDED", "error": "Slow down", "retry_after_ms": 1})
+				case http.StatusForbidden:
+					w.WriteHeader(status)
+					writeJSON(t, w, map[string]any{"errcode": "M_FORBIDDEN", "error": "Forbidden"})
+				default:
+					w.WriteHeader(status)
+					writeJSON(t, w, map[string]any{"errcode": "M_UNKNOWN", "error": "Unavailable"})
+				}
+			}))
+			defer server.Close()
+
+			client := newTestClient(t, server, "@self:example.com")
+			attempts, err := retry(context.Background(), testRetryPolicy, func() error {
+				_, err := client.JoinedMembers(context.Background(), "!room:example.com")
+				return err
+			})
+
+			if attempts != test.expectedAttempts {
+				t.Fatalf("expected %d attempts, got %d", test.expectedAttempts, attempts)
+			}
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error %t, got %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	policy := testRetryPolicy
+	policy.Deadline = 10 * time.Millisecond
+	rateLimited := newError(ErrorCategoryRateLimited, errors.New("slow down"))
+	rateLimited.RetryAfter = time.Minute
+
+	attempts, err := retry(context.Background(), policy, func() error {
+		return rateLimited
+	})
+	if attempts != 1 || !errors.Is(err, rateLimited) {
+		t.Fatalf("expected a single attempt when the retry delay exceeds the deadline, got %d attempts and %v", attempts, err)
+	}
+}
+
+func TestSendRetriesWithSameTransactionId(t *testing.T) {
+	var transactionIds []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/state"):
+			writeJSON(t, w, []any{})
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/send/"):
+			transactionIds = append(transactionIds, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+			if len(transactionIds) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				writeJSON(t, w, map[string]string{"errcode": "M_UNKNOWN", "error": "Bad gateway"})
+				return
+			}
+			writeJSON(t, w, map[string]string{"event_id": "$sent"})
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	session := &Session{client: newTestClient(t, server, "@self:example.com"), retryPolicy: testRetryPolicy}
+
+	result := session.deliver(context.Background(), "!room:example.com", session.sendCallback(&event.MessageEventContent{MsgType: event.MsgNotice, Body: "disk full"}, nil))
+	if result.Err != nil {
+		t.Fatalf("expected no error, got %v", result.Err)
+	}
+	if result.Attempts != 2 || result.EventId != "$sent" || result.RoomId != id.RoomID("!room:example.com") {
+		t.Fatalf("expected $sent after 2 attempts, got %+v", result)
+	}
+	if len(transactionIds) != 2 || transactionIds[0] != transactionIds[1] {
+		t.Fatalf("expected the same transaction ID for both attempts, got %v", transactionIds)
+	}
+}
+
+func TestRetryUsesContextDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rateLimited := newError(ErrorCategoryRateLimited, errors.New("slow down"))
+	rateLimited.RetryAfter = 100 * time.Millisecond
+
+	attempts, err := retry(ctx, testRetryPolicy, func() error {
+		return rateLimited
+	})
+	if attempts != 1 || !errors.Is(err, rateLimited) {
+		t.Fatalf("expected a single attempt when the retry delay exceeds the context deadline, got %d attempts and %v", attempts, err)
+	}
+}
+
+func TestReactRetriesWithSameTransactionId(t *testing.T) {
+	var transactionIds []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/state"):
+			writeJSON(t, w, []any{})
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/send/m.reaction/"):
+			transactionIds = append(transactionIds, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+			if len(transactionIds) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				writeJSON(t, w, map[string]string{"errcode": "M_UNKNOWN", "error": "Bad gateway"})
+				return
+			}
+			writeJSON(t, w, map[string]string{"event_id": "$reaction"})
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	session := &Session{client: newTestClient(t, server, "@self:example.com"), retryPolicy: testRetryPolicy}
+
+	eventId, err := session.React(context.Background(), "!room:example.com", "$alert", "✅")
+	if err != nil || eventId != "$reaction" {
+		t.Fatalf("expected $reaction, got %s, %v", eventId, err)
+	}
+	if len(transactionIds) != 2 || transactionIds[0] != transactionIds[1] {
+		t.Fatalf("expected the same transaction ID for both attempts, got %v", transactionIds)
+	}
+}
+
+func TestSendAttachmentRetriesWithoutUploadingAgain(t *testing.T) {
+	uploads := 0
+	sends := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/upload"):
+			uploads++
+			writeJSON(t, w, map[string]string{"content_uri": "mxc://example.com/media"})
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/state"):
+			writeJSON(t, w, []any{})
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/send/"):
+			sends++
+			if sends == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				writeJSON(t, w, map[string]string{"errcode": "M_UNKNOWN", "error": "Bad gateway"})
+				return
+			}
+			writeJSON(t, w, map[string]string{"event_id": "$sent"})
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	session := &Session{client: newTestClient(t, server, "@self:example.com"), retryPolicy: testRetryPolicy}
+
+	eventId, err := session.SendAttachment(context.Background(), "m.file", &Attachment{FileName: "report.txt", Data: []byte("report")}, "!room:example.com", nil)
+	if err != nil || eventId != "$sent" {
+		t.Fatalf("expected $sent, got %s, %v", eventId, err)
+	}
+	if uploads != 1 || sends != 2 {
+		t.Fatalf("expected one upload for two send attempts, got %d uploads and %d sends", uploads, sends)
+	}
+}
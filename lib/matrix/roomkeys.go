@@ -0,0 +1,88 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrNoRoomKeys = errors.New("there are no room keys to export")
+
+// ExportRoomKeys returns all the room keys of the device as a passphrase encrypted key export file,
+// the same format Element uses for its "Export E2E room keys"
+func ExportRoomKeys(
+	ctx context.Context,
+	databaseDsn string,
+	accessToken string,
+	pickleKey []byte,
+	url string,
+	deviceId id.DeviceID,
+	passphrase string,
+	clientFactory MautrixFactory,
+) (export []byte, count int, err error) {
+	if passphrase == "" {
+		err = newError(ErrorCategoryInvalidRequest, errors.New("the passphrase of the key export cannot be empty"))
+		return
+	}
+
+	_, _, helper, database, _, err := prepareClient(ctx, databaseDsn, accessToken, pickleKey, url, deviceId, DefaultRetryPolicy, clientFactory)
+	if err != nil {
+		return
+	}
+	defer database.Close()
+
+	return exportRoomKeys(ctx, helper.Machine().CryptoStore, passphrase)
+}
+
+// ImportRoomKeys stores the room keys from a key export file, the keys already known in a better state are skipped
+func ImportRoomKeys(
+	ctx context.Context,
+	databaseDsn string,
+	accessToken string,
+	pickleKey []byte,
+	url string,
+	deviceId id.DeviceID,
+	passphrase string,
+	export []byte,
+	clientFactory MautrixFactory,
+) (imported int, total int, err error) {
+	_, _, helper, database, _, err := prepareClient(ctx, databaseDsn, accessToken, pickleKey, url, deviceId, DefaultRetryPolicy, clientFactory)
+	if err != nil {
+		return
+	}
+	defer database.Close()
+
+	return importRoomKeys(ctx, helper.Machine(), passphrase, export)
+}
+
+func exportRoomKeys(ctx context.Context, store crypto.Store, passphrase string) (export []byte, count int, err error) {
+	sessions, err := store.GetAllGroupSessions(ctx).AsList()
+	if err != nil {
+		err = storeError(err)
+		return
+	}
+	if len(sessions) == 0 {
+		err = ErrNoRoomKeys
+		return
+	}
+
+	export, err = crypto.ExportKeys(passphrase, sessions)
+	if err != nil {
+		return
+	}
+
+	return export, len(sessions), nil
+}
+
+func importRoomKeys(ctx context.Context, machine *crypto.OlmMachine, passphrase string, export []byte) (imported int, total int, err error) {
+	imported, total, err = machine.ImportKeys(ctx, passphrase, export)
+	if err != nil && ctx.Err() == nil {
+		// the file can't be read, either it's not a key export or the passphrase is wrong
+		err = newError(ErrorCategoryInvalidRequest, fmt.Errorf("failed to read the key export: %w", err))
+	}
+
+	return
+}
@@ -0,0 +1,83 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+)
+
+func newTestMachine(t *testing.T) *crypto.OlmMachine {
+	t.Helper()
+
+	log := zerolog.Nop()
+	return crypto.NewOlmMachine(&mautrix.Client{UserID: "@bot:example.org", Log: log}, &log, crypto.NewMemoryStore(nil), mautrix.NewMemoryStateStore().(crypto.StateStore))
+}
+
+func TestRoomKeysExportAndImport(t *testing.T) {
+	ctx := context.Background()
+	roomId := id.RoomID("!room:example.org")
+
+	outbound, err := crypto.NewOutboundGroupSession(roomId, nil)
+	if err != nil {
+		t.Fatalf("failed to create outbound session: %v", err)
+	}
+	inbound, err := crypto.NewInboundGroupSession("sender", "signing", roomId, outbound.Internal.Key(), 0, 0, false)
+	if err != nil {
+		t.Fatalf("failed to create inbound session: %v", err)
+	}
+	source := newTestMachine(t)
+	_ = source.CryptoStore.PutGroupSession(ctx, inbound)
+
+	export, count, err := exportRoomKeys(ctx, source.CryptoStore, "passphrase")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if count != 1 || !strings.HasPrefix(string(export), "-----BEGIN MEGOLM SESSION DATA-----") {
+		t.Fatalf("expected a key export with one session, got %d: %s", count, export)
+	}
+
+	target := newTestMachine(t)
+	_, _, err = importRoomKeys(ctx, target, "wrong", export)
+	var matrixErr *Error
+	if !errors.As(err, &matrixErr) || matrixErr.Category != ErrorCategoryInvalidRequest {
+		t.Fatalf("expected an invalid request error for a wrong passphrase, got %v", err)
+	}
+
+	imported, total, err := importRoomKeys(ctx, target, "passphrase", export)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if imported != 1 || total != 1 {
+		t.Fatalf("expected 1 of 1 sessions to be imported, got %d of %d", imported, total)
+	}
+	session, err := target.CryptoStore.GetGroupSession(ctx, roomId, inbound.ID())
+	if err != nil || session == nil || session.SenderKey != "sender" {
+		t.Fatalf("expected the imported session in the store, got %v, %v", session, err)
+	}
+
+	imported, _, err = importRoomKeys(ctx, target, "passphrase", export)
+	if err != nil || imported != 0 {
+		t.Fatalf("expected the known session to be skipped, got %d, %v", imported, err)
+	}
+}
+
+func TestRoomKeysExportEmpty(t *testing.T) {
+	_, _, err := exportRoomKeys(context.Background(), crypto.NewMemoryStore(nil), "passphrase")
+	if !errors.Is(err, ErrNoRoomKeys) {
+		t.Fatalf("expected ErrNoRoomKeys, got %v", err)
+	}
+}
+
+func TestExportRoomKeysRequiresPassphrase(t *testing.T) {
+	_, _, err := ExportRoomKeys(context.Background(), "", "", nil, "", "", "", nil)
+	var matrixErr *Error
+	if !errors.As(err, &matrixErr) || matrixErr.Category != ErrorCategoryInvalidRequest {
+		t.Fatalf("expected an invalid request error, got %v", err)
+	}
+}
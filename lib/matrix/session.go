@@ -0,0 +1,745 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lib/db"
+	"lib/store"
+	"lib/types"
+	"sync"
+	"sync/atomic"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrSessionClosed = errors.New("the session is closed")
+	ErrNoRecipients  = errors.New("no recipients were provided")
+)
+
+type RecipientResult struct {
+	RoomId   id.RoomID
+	EventId  string
+	Attempts int
+	Withheld []WithheldDevice
+	Err      error
+}
+
+type SessionHealth struct {
+	Syncing          bool
+	CryptoReady      bool
+	KeyBackupVersion id.KeyBackupVersion
+	SyncErr          error
+}
+
+type Session struct {
+	client   *mautrix.Client
+	crypto   *cryptohelper.CryptoHelper
+	database *dbutil.Database
+	store    *store.NotifierStore
+
+	retryPolicy RetryPolicy
+	trust       *trustStore
+	keyBackup   *keyBackup
+	// the key backup is uploaded in the background with the context of the sync loop
+	backupContext context.Context
+	backupRunning atomic.Bool
+	backupWait    sync.WaitGroup
+
+	lock       sessionLock
+	closed     atomic.Bool
+	rooms      map[string]id.RoomID
+	roomsLock  sync.Mutex
+	stopSync   context.CancelFunc
+	syncDone   chan struct{}
+	syncErr    error
+	syncErrMux sync.Mutex
+
+	incoming     []IncomingMessage
+	lastSequence uint64
+	incomingLock sync.Mutex
+
+	invitePolicy   *InvitePolicy
+	invites        []InviteDecision
+	pendingInvites map[id.RoomID]struct{}
+	invitesLock    sync.Mutex
+	invitesWait    sync.WaitGroup
+}
+
+func OpenSession(
+	ctx context.Context,
+	databaseDsn string,
+	accessToken string,
+	recoveryKey string,
+	pickleKey []byte,
+	url string,
+	deviceId id.DeviceID,
+	retryPolicy *RetryPolicy,
+	invitePolicy *InvitePolicy,
+	deviceTrust DeviceTrustMode,
+	receiveMessages bool,
+	clientFactory MautrixFactory,
+) (session *Session, err error) {
+	if retryPolicy == nil {
+		retryPolicy = &DefaultRetryPolicy
+	}
+	minTrust, err := deviceTrust.minTrust()
+	if err != nil {
+		return
+	}
+
+	client, syncer, crypto, database, notifierStore, err := prepareClient(ctx, databaseDsn, accessToken, pickleKey, url, deviceId, *retryPolicy, clientFactory)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = database.Close()
+		}
+	}()
+
+	client.Store = store.NewSyncStore(notifierStore, deviceId, receiveMessages)
+	syncer.FilterJSON = syncFilter(receiveMessages)
+
+	machine := crypto.Machine()
+	trust := &trustStore{Store: machine.CryptoStore, ownUserId: client.UserID, minTrust: minTrust}
+	machine.CryptoStore = trust
+	if minTrust > id.TrustStateUnset {
+		// the devices of the users below the minimal trust look like they're not cross-signed, see trustStore
+		machine.SendKeysMinTrust = id.TrustStateCrossSignedUntrusted
+	}
+
+	readyChan := make(chan error, 1)
+	var onceSetupEncryption sync.Once
+
+	syncer.OnSync(func(ctx context.Context, resp *mautrix.RespSync, since string) bool {
+		onceSetupEncryption.Do(func() {
+			readyChan <- bootstrapCrossSigning(ctx, client, crypto.Machine(), notifierStore, recoveryKey)
+		})
+
+		return true
+	})
+
+	session = &Session{
+		client:       client,
+		crypto:       crypto,
+		database:     database,
+		store:        notifierStore,
+		retryPolicy:  *retryPolicy,
+		trust:        trust,
+		invitePolicy: invitePolicy,
+		syncDone:     make(chan struct{}),
+	}
+	if receiveMessages {
+		syncer.OnSync(dropHistory)
+		syncer.OnEventType(event.EventMessage, session.receiveMessage)
+	}
+	if invitePolicy != nil {
+		syncer.OnEventType(event.StateMember, session.handleInvite)
+	}
+
+	// the session outlives the context used to open it, cancelling it only aborts the opening
+	syncContext, stopSync := context.WithCancel(context.WithoutCancel(ctx))
+	session.stopSync = stopSync
+	session.backupContext = syncContext
+
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(session.syncDone)
+
+		err := client.SyncWithContext(syncContext)
+		if syncContext.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("the sync loop stopped unexpectedly")
+		}
+
+		session.syncErrMux.Lock()
+		session.syncErr = err
+		session.syncErrMux.Unlock()
+
+		select {
+		case errChan <- err:
+		default:
+		}
+	}()
+
+	select {
+	case err = <-readyChan:
+	case err = <-errChan:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		session.stopSyncLoop()
+		session = nil
+		return
+	}
+
+	// messages can be sent without the key backup, so it doesn't fail the session
+	session.keyBackup, err = enableKeyBackup(ctx, client, machine, recoveryKey)
+	if err != nil {
+		client.Log.Warn().Err(err).Msg("Failed to enable the key backup")
+		err = nil
+	}
+	session.backupRoomKeys()
+
+	return
+}
+
+func prepareClient(
+	ctx context.Context,
+	databaseDsn string,
+	accessToken string,
+	pickleKey []byte,
+	url string,
+	deviceId id.DeviceID,
+	retryPolicy RetryPolicy,
+	clientFactory MautrixFactory,
+) (
+	client *mautrix.Client,
+	syncer *mautrix.DefaultSyncer,
+	crypto *cryptohelper.CryptoHelper,
+	database *dbutil.Database,
+	notifierStore *store.NotifierStore,
+	err error,
+) {
+	databaseProvider, err := db.FindProvider(databaseDsn)
+	if err != nil {
+		return
+	}
+	database, err = databaseProvider.Get(databaseDsn)
+	if err != nil {
+		err = newError(ErrorCategoryCryptoStoreError, err)
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = database.Close()
+		}
+	}()
+
+	if clientFactory == nil {
+		clientFactory = func() (*mautrix.Client, error) {
+			return mautrix.NewClient(url, "", accessToken)
+		}
+	}
+
+	client, err = clientFactory()
+	if err != nil {
+		return
+	}
+	var whoami *mautrix.RespWhoami
+	_, err = retry(ctx, retryPolicy, func() (err error) {
+		whoami, err = client.Whoami(ctx)
+		return
+	})
+	if err != nil {
+		return
+	}
+	client.UserID = whoami.UserID
+
+	syncer = mautrix.NewDefaultSyncer()
+	syncer.FilterJSON = syncFilter(false)
+
+	client.DeviceID = deviceId
+	client.Syncer = syncer
+
+	crypto, err = initializeEncryption(ctx, client, pickleKey, database)
+	if err != nil {
+		err = storeError(err)
+		return
+	}
+
+	notifierStore = store.NewNotifierStore(database)
+	err = notifierStore.Upgrade(ctx)
+	if err != nil {
+		err = newError(ErrorCategoryCryptoStoreError, err)
+		return
+	}
+
+	return
+}
+
+func (receiver *Session) SendMessage(
+	ctx context.Context,
+	messageType types.MessageType,
+	renderingType types.RenderingType,
+	message string,
+	recipient string,
+	options *MessageOptions,
+) (string, error) {
+	var file *Attachment
+	if messageType.IsAttachment() {
+		var err error
+		file, err = LoadAttachment(message)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return receiver.send(ctx, messageType, renderingType, message, file, recipient, options)
+}
+
+func (receiver *Session) SendMessageToRecipients(
+	ctx context.Context,
+	messageType types.MessageType,
+	renderingType types.RenderingType,
+	message string,
+	recipients []string,
+	options *MessageOptions,
+) (map[string]RecipientResult, error) {
+	var file *Attachment
+	if messageType.IsAttachment() {
+		var err error
+		file, err = LoadAttachment(message)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return receiver.sendToRecipients(ctx, messageType, renderingType, message, file, recipients, options)
+}
+
+func (receiver *Session) SendAttachment(
+	ctx context.Context,
+	messageType types.MessageType,
+	file *Attachment,
+	recipient string,
+	options *MessageOptions,
+) (string, error) {
+	if !messageType.IsAttachment() {
+		return "", newError(ErrorCategoryInvalidRequest, fmt.Errorf("message type %s cannot be used for attachments", messageType))
+	}
+
+	return receiver.send(ctx, messageType, "", "", file, recipient, options)
+}
+
+func (receiver *Session) SendAttachmentToRecipients(
+	ctx context.Context,
+	messageType types.MessageType,
+	file *Attachment,
+	recipients []string,
+	options *MessageOptions,
+) (map[string]RecipientResult, error) {
+	if !messageType.IsAttachment() {
+		return nil, newError(ErrorCategoryInvalidRequest, fmt.Errorf("message type %s cannot be used for attachments", messageType))
+	}
+
+	return receiver.sendToRecipients(ctx, messageType, "", "", file, recipients, options)
+}
+
+func (receiver *Session) EditMessage(
+	ctx context.Context,
+	messageType types.MessageType,
+	renderingType types.RenderingType,
+	message string,
+	recipient string,
+	eventId id.EventID,
+) (string, error) {
+	transactionId := receiver.client.TxnID()
+
+	return receiver.inRoom(ctx, recipient, func(ctx context.Context, roomId id.RoomID) (*mautrix.RespSendEvent, error) {
+		return editInRoom(ctx, receiver.client, roomId, eventId, messageType, renderingType, message, transactionId)
+	})
+}
+
+func (receiver *Session) RedactMessage(ctx context.Context, recipient string, eventId id.EventID, reason string) (string, error) {
+	transactionId := receiver.client.TxnID()
+
+	return receiver.inRoom(ctx, recipient, func(ctx context.Context, roomId id.RoomID) (*mautrix.RespSendEvent, error) {
+		return redactInRoom(ctx, receiver.client, roomId, eventId, reason, transactionId)
+	})
+}
+
+func (receiver *Session) React(ctx context.Context, recipient string, eventId id.EventID, key string) (string, error) {
+	transactionId := receiver.client.TxnID()
+
+	return receiver.inRoom(ctx, recipient, func(ctx context.Context, roomId id.RoomID) (*mautrix.RespSendEvent, error) {
+		return reactInRoom(ctx, receiver.client, roomId, eventId, key, transactionId)
+	})
+}
+
+func (receiver *Session) Unreact(ctx context.Context, recipient string, eventId id.EventID, key string) (string, error) {
+	transactionId := receiver.client.TxnID()
+
+	return receiver.inRoom(ctx, recipient, func(ctx context.Context, roomId id.RoomID) (*mautrix.RespSendEvent, error) {
+		return unreactInRoom(ctx, receiver.client, roomId, eventId, key, transactionId)
+	})
+}
+
+func (receiver *Session) send(
+	ctx context.Context,
+	messageType types.MessageType,
+	renderingType types.RenderingType,
+	message string,
+	file *Attachment,
+	recipient string,
+	options *MessageOptions,
+) (string, error) {
+	content, err := createContent(ctx, receiver.client, receiver.retryPolicy, messageType, renderingType, message, file)
+	if err != nil {
+		return "", err
+	}
+
+	return receiver.inRoom(ctx, recipient, receiver.sendCallback(content, options))
+}
+
+// sendCallback keeps the same transaction ID across retries so the homeserver deduplicates repeated sends,
+// events sent with an idempotency key are remembered so that sending them again returns the original event.
+// The content is created before, so an attachment is uploaded once however many times the event is sent
+func (receiver *Session) sendCallback(
+	content *event.MessageEventContent,
+	options *MessageOptions,
+) func(ctx context.Context, roomId id.RoomID) (*mautrix.RespSendEvent, error) {
+	if options == nil || options.IdempotencyKey == "" {
+		transactionId := receiver.client.TxnID()
+
+		return func(ctx context.Context, roomId id.RoomID) (*mautrix.RespSendEvent, error) {
+			return sendToRoom(ctx, receiver.client, roomId, content, options, transactionId)
+		}
+	}
+
+	transactionId := options.IdempotencyKey
+	deviceId := receiver.client.DeviceID
+
+	return func(ctx context.Context, roomId id.RoomID) (*mautrix.RespSendEvent, error) {
+		eventId, err := receiver.store.GetSentTransaction(ctx, deviceId, transactionId, roomId)
+		if err != nil {
+			return nil, newError(ErrorCategoryCryptoStoreError, err)
+		}
+		if eventId != "" {
+			return &mautrix.RespSendEvent{EventID: eventId}, nil
+		}
+
+		response, err := sendToRoom(ctx, receiver.client, roomId, content, options, transactionId)
+		if err != nil {
+			return nil, err
+		}
+
+		err = receiver.store.SetSentTransaction(ctx, deviceId, transactionId, roomId, response.EventID)
+		if err != nil {
+			return nil, newError(ErrorCategoryCryptoStoreError, err)
+		}
+
+		return response, nil
+	}
+}
+
+func (receiver *Session) sendToRecipients(
+	ctx context.Context,
+	messageType types.MessageType,
+	renderingType types.RenderingType,
+	message string,
+	file *Attachment,
+	recipients []string,
+	options *MessageOptions,
+) (map[string]RecipientResult, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	// the attachment is uploaded once and the same content is sent to every room
+	content, err := createContent(ctx, receiver.client, receiver.retryPolicy, messageType, renderingType, message, file)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]RecipientResult, len(recipients))
+	for _, recipient := range recipients {
+		if _, ok := results[recipient]; ok {
+			continue
+		}
+
+		result := receiver.deliver(ctx, recipient, receiver.sendCallback(content, options))
+		if errors.Is(result.Err, ErrSessionClosed) {
+			return nil, result.Err
+		}
+		results[recipient] = result
+	}
+
+	return results, nil
+}
+
+func (receiver *Session) inRoom(
+	ctx context.Context,
+	recipient string,
+	callback func(ctx context.Context, roomId id.RoomID) (*mautrix.RespSendEvent, error),
+) (string, error) {
+	result := receiver.deliver(ctx, recipient, callback)
+
+	return result.EventId, result.Err
+}
+
+// deliver resolves the recipient and runs the callback in its room, every network step is retried according
+// to the retry policy within one deadline per delivery and the attempts of the callback are reported in the result.
+// The session is held only while an attempt runs, so the backoff doesn't block the other callers or closing it
+func (receiver *Session) deliver(
+	ctx context.Context,
+	recipient string,
+	callback func(ctx context.Context, roomId id.RoomID) (*mautrix.RespSendEvent, error),
+) (result RecipientResult) {
+	if receiver.retryPolicy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, receiver.retryPolicy.Deadline)
+		defer cancel()
+	}
+
+	if receiver.closed.Load() {
+		result.Err = ErrSessionClosed
+		return
+	}
+	if err := receiver.syncError(); err != nil {
+		result.Err = fmt.Errorf("the session sync loop has stopped: %w", err)
+		return
+	}
+
+	result.RoomId, result.Err = receiver.resolve(ctx, recipient)
+	if result.Err != nil {
+		return
+	}
+
+	_, result.Err = retry(ctx, receiver.retryPolicy, func() error {
+		return receiver.locked(ctx, func() error {
+			_, err := receiver.client.State(ctx, result.RoomId)
+			return err
+		})
+	})
+	if result.Err != nil {
+		return
+	}
+
+	var response *mautrix.RespSendEvent
+	result.Attempts, result.Err = retry(ctx, receiver.retryPolicy, func() error {
+		return receiver.locked(ctx, func() (err error) {
+			if err = receiver.shareRoomKeys(ctx, result.RoomId); err != nil {
+				return
+			}
+			response, err = callback(ctx, result.RoomId)
+			return
+		})
+	})
+	if result.Err != nil {
+		return
+	}
+	result.EventId = string(response.EventID)
+	_ = receiver.locked(ctx, func() error {
+		result.Withheld = receiver.withheldDevices(ctx, result.RoomId)
+		receiver.backupRoomKeys()
+		return nil
+	})
+
+	return
+}
+
+// locked runs the operation while holding the session, waiting for it gives up when the context is done
+func (receiver *Session) locked(ctx context.Context, operation func() error) error {
+	if err := receiver.lock.acquire(ctx); err != nil {
+		return err
+	}
+	defer receiver.lock.release()
+
+	if receiver.closed.Load() {
+		return ErrSessionClosed
+	}
+
+	return operation()
+}
+
+// shareRoomKeys shares the room keys before the message is encrypted, the cross-signing keys are only hidden while
+// the olm machine looks up the trust of the devices, the missing device lists are fetched beforehand with the plain
+// context, so the stored keys and their signatures are kept up to date
+func (receiver *Session) shareRoomKeys(ctx context.Context, roomId id.RoomID) error {
+	if receiver.crypto == nil {
+		return nil
+	}
+	encrypted, err := receiver.client.StateStore.IsEncrypted(ctx, roomId)
+	if err != nil || !encrypted {
+		return err
+	}
+
+	members, err := receiver.client.StateStore.GetRoomJoinedOrInvitedMembers(ctx, roomId)
+	if err != nil {
+		return err
+	}
+
+	machine := receiver.crypto.Machine()
+	var missing []id.UserID
+	for _, userId := range members {
+		devices, err := machine.CryptoStore.GetDevices(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if devices == nil {
+			missing = append(missing, userId)
+		}
+	}
+	if len(missing) > 0 {
+		if _, err := machine.FetchKeys(ctx, missing, true); err != nil {
+			return err
+		}
+	}
+
+	return machine.ShareGroupSession(withSharingKeys(ctx), roomId, members)
+}
+
+// withheldDevices doesn't fail the delivery, the message has already been sent
+func (receiver *Session) withheldDevices(ctx context.Context, roomId id.RoomID) []WithheldDevice {
+	if receiver.trust == nil {
+		return nil
+	}
+
+	withheld, err := receiver.trust.withheldDevices(ctx, receiver.client, roomId)
+	if err != nil {
+		receiver.client.Log.Warn().Err(err).Stringer("room_id", roomId).Msg("Failed to list the withheld devices")
+		return nil
+	}
+
+	for _, device := range withheld {
+		if device.Reason == WithheldReasonKeyChanged {
+			receiver.client.Log.Warn().
+				Stringer("room_id", roomId).
+				Stringer("user_id", device.UserId).
+				Stringer("device_id", device.DeviceId).
+				Msg("The master key of the user has changed, the room keys were withheld from their device")
+		}
+	}
+
+	return withheld
+}
+
+// backupRoomKeys uploads the room keys in the background, so the delivery doesn't wait for the key backup. It's started
+// while holding the session, so closing it waits for the upload. Only one upload runs at a time, the sessions which
+// failed to upload or were created meanwhile are uploaded after the next message
+func (receiver *Session) backupRoomKeys() {
+	if receiver.keyBackup == nil || !receiver.backupRunning.CompareAndSwap(false, true) {
+		return
+	}
+
+	receiver.backupWait.Add(1)
+	go func() {
+		defer receiver.backupWait.Done()
+		defer receiver.backupRunning.Store(false)
+
+		machine := receiver.crypto.Machine()
+		count, err := receiver.keyBackup.upload(receiver.backupContext, receiver.client, machine.CryptoStore, machine.OwnIdentity().IdentityKey)
+		if count > 0 {
+			receiver.client.Log.Debug().Int("count", count).Stringer("key_backup_version", receiver.keyBackup.version).Msg("Uploaded the room keys to the key backup")
+		}
+		if err != nil {
+			receiver.client.Log.Warn().Err(err).Stringer("key_backup_version", receiver.keyBackup.version).Msg("Failed to upload the room keys to the key backup")
+		}
+	}()
+}
+
+// ResolveRoom returns the room a recipient was delivered to, recipients are only resolved once per session
+func (receiver *Session) ResolveRoom(ctx context.Context, recipient string) (id.RoomID, error) {
+	if receiver.closed.Load() {
+		return "", ErrSessionClosed
+	}
+
+	return receiver.resolve(ctx, recipient)
+}
+
+// resolve doesn't hold the session while the lookups are retried, if another caller resolved the same
+// recipient in the meantime, its room is kept
+func (receiver *Session) resolve(ctx context.Context, recipient string) (id.RoomID, error) {
+	receiver.roomsLock.Lock()
+	roomId, ok := receiver.rooms[recipient]
+	receiver.roomsLock.Unlock()
+	if ok {
+		return roomId, nil
+	}
+
+	roomId, err := resolveRecipient(ctx, receiver.client, recipient, receiver.retryPolicy)
+	if err != nil {
+		return "", err
+	}
+
+	receiver.roomsLock.Lock()
+	defer receiver.roomsLock.Unlock()
+
+	if resolved, ok := receiver.rooms[recipient]; ok {
+		return resolved, nil
+	}
+	if receiver.rooms == nil {
+		receiver.rooms = make(map[string]id.RoomID)
+	}
+	receiver.rooms[recipient] = roomId
+
+	return roomId, nil
+}
+
+func (receiver *Session) syncError() error {
+	receiver.syncErrMux.Lock()
+	defer receiver.syncErrMux.Unlock()
+
+	return receiver.syncErr
+}
+
+// Health doesn't wait for the operations in progress, so it can be used while a message is being sent
+func (receiver *Session) Health() (health SessionHealth) {
+	health.SyncErr = receiver.syncError()
+	select {
+	case <-receiver.syncDone:
+	default:
+		health.Syncing = health.SyncErr == nil
+	}
+
+	account := receiver.crypto.Machine().GetAccount()
+	health.CryptoReady = account != nil && account.Shared
+	if receiver.keyBackup != nil {
+		health.KeyBackupVersion = receiver.keyBackup.version
+	}
+
+	return
+}
+
+// Close waits for the attempt in progress, the deliveries waiting for a retry fail with ErrSessionClosed
+func (receiver *Session) Close() error {
+	_ = receiver.lock.acquire(context.Background())
+	defer receiver.lock.release()
+
+	if receiver.closed.Swap(true) {
+		return ErrSessionClosed
+	}
+	receiver.stopSyncLoop()
+
+	return receiver.database.Close()
+}
+
+func (receiver *Session) stopSyncLoop() {
+	receiver.stopSync()
+	<-receiver.syncDone
+	receiver.invitesWait.Wait()
+	receiver.backupWait.Wait()
+}
+
+// sessionLock is a mutex whose waiting respects the context, the zero value is unlocked
+type sessionLock struct {
+	init      sync.Once
+	semaphore chan struct{}
+}
+
+func (receiver *sessionLock) acquire(ctx context.Context) error {
+	receiver.init.Do(func() {
+		receiver.semaphore = make(chan struct{}, 1)
+	})
+
+	select {
+	case receiver.semaphore <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (receiver *sessionLock) release() {
+	<-receiver.semaphore
+}
@@ -0,0 +1,212 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"lib/db"
+	"lib/types"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestOpenSessionInvalidDsn(t *testing.T) {
+	_, err := OpenSession(context.Background(), "invalid", "token", "recovery", []byte("secret"), "https://example.org", "DEVICE", nil, nil, "", false, nil)
+	if !errors.Is(err, db.ErrUnsupportedDsn) {
+		t.Fatalf("expected ErrUnsupportedDsn, got %v", err)
+	}
+}
+
+func TestOpenSessionWhoamiError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeJSON(t, w, map[string]string{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid token"})
+	}))
+	defer server.Close()
+
+	databasePath := filepath.Join(t.TempDir(), "crypto.db")
+	_, err := OpenSession(context.Background(), databasePath, "token", "recovery", []byte("secret"), server.URL, "DEVICE", nil, nil, "", false, func() (*mautrix.Client, error) {
+		return newTestClient(t, server, ""), nil
+	})
+	if !errors.Is(err, mautrix.MUnknownToken) {
+		t.Fatalf("expected M_UNKNOWN_TOKEN error, got %v", err)
+	}
+}
+
+func TestOpenSessionCancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	databasePath := filepath.Join(t.TempDir(), "crypto.db")
+	_, err := OpenSession(ctx, databasePath, "token", "recovery", []byte("secret"), server.URL, "DEVICE", nil, nil, "", false, func() (*mautrix.Client, error) {
+		return newTestClient(t, server, ""), nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled error, got %v", err)
+	}
+}
+
+func TestClosedSession(t *testing.T) {
+	session := &Session{}
+	session.closed.Store(true)
+
+	_, err := session.SendMessage(context.Background(), types.MessageTypeTextMessage, types.RenderingTypePlainText, "hello", "!room:example.com", nil)
+	if !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("expected ErrSessionClosed, got %v", err)
+	}
+
+	err = session.Close()
+	if !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("expected ErrSessionClosed, got %v", err)
+	}
+}
+
+func TestDeliverWaitsForTheSessionWithinTheContext(t *testing.T) {
+	session := &Session{retryPolicy: testRetryPolicy}
+	if err := session.lock.acquire(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer session.lock.release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := session.SendMessage(ctx, types.MessageTypeTextMessage, types.RenderingTypePlainText, "hello", "!room:example.com", nil)
+	if Classify(err).Category != ErrorCategoryTimeout {
+		t.Fatalf("expected a timeout while the session is held, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("expected the wait to end with the context, took %s", elapsed)
+	}
+}
+
+func TestSendToRecipients(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/state"):
+			writeJSON(t, w, []any{})
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/rooms/!team:example.com/send/"):
+			writeJSON(t, w, map[string]string{"event_id": "$team"})
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/send/"):
+			w.WriteHeader(http.StatusForbidden)
+			writeJSON(t, w, map[string]string{"errcode": "M_FORBIDDEN", "error": "Not in room"})
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	session := &Session{client: newTestClient(t, server, "@self:example.com")}
+
+	results, err := session.sendToRecipients(
+		context.Background(),
+		types.MessageTypeNotice,
+		types.RenderingTypePlainText,
+		"disk full",
+		nil,
+		[]string{"!escalation:example.com", "!team:example.com", "unknown", "!team:example.com"},
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if result := results["!team:example.com"]; result.Err != nil || result.EventId != "$team" || result.RoomId != "!team:example.com" {
+		t.Fatalf("expected event ID $team, got %+v", result)
+	}
+	if result := results["!escalation:example.com"]; !errors.Is(result.Err, mautrix.MForbidden) {
+		t.Fatalf("expected M_FORBIDDEN error, got %+v", result)
+	}
+	if result := results["unknown"]; result.Err == nil {
+		t.Fatalf("expected error for unknown recipient, got %+v", result)
+	}
+
+	_, err = session.sendToRecipients(context.Background(), types.MessageTypeNotice, types.RenderingTypePlainText, "disk full", nil, nil, nil)
+	if !errors.Is(err, ErrNoRecipients) {
+		t.Fatalf("expected ErrNoRecipients, got %v", err)
+	}
+}
+
+func TestSendWithIdempotencyKey(t *testing.T) {
+	var transactionIds []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/state"):
+			writeJSON(t, w, []any{})
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/send/"):
+			transactionIds = append(transactionIds, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+			writeJSON(t, w, map[string]string{"event_id": "$original"})
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server, "@self:example.com")
+	client.DeviceID = "DEVICE"
+	session := &Session{client: client, store: newTestNotifierStore(t), retryPolicy: DefaultRetryPolicy}
+	options := &MessageOptions{IdempotencyKey: "alert-42"}
+
+	for range 2 {
+		eventId, err := session.SendMessage(context.Background(), types.MessageTypeNotice, types.RenderingTypePlainText, "disk full", "!room:example.com", options)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if eventId != "$original" {
+			t.Fatalf("expected event ID $original, got %s", eventId)
+		}
+	}
+
+	if len(transactionIds) != 1 || transactionIds[0] != "alert-42" {
+		t.Fatalf("expected a single send with transaction ID alert-42, got %v", transactionIds)
+	}
+}
+
+func TestSendAttachmentToRecipientsUploadsOnce(t *testing.T) {
+	uploads := 0
+	var rooms []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/upload"):
+			uploads++
+			writeJSON(t, w, map[string]string{"content_uri": "mxc://example.com/media"})
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/state"):
+			writeJSON(t, w, []any{})
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/send/"):
+			rooms = append(rooms, strings.Split(r.URL.Path, "/")[5])
+			writeJSON(t, w, map[string]string{"event_id": "$sent"})
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	session := &Session{client: newTestClient(t, server, "@self:example.com"), retryPolicy: testRetryPolicy}
+
+	results, err := session.SendAttachmentToRecipients(context.Background(), "m.file", &Attachment{FileName: "report.txt", Data: []byte("report")}, []string{"!a:example.com", "!b:example.com"}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(results) != 2 || results["!a:example.com"].Err != nil || results["!b:example.com"].Err != nil {
+		t.Fatalf("expected both recipients to succeed, got %+v", results)
+	}
+	if uploads != 1 || len(rooms) != 2 {
+		t.Fatalf("expected one upload for two rooms, got %d uploads and %v", uploads, rooms)
+	}
+}
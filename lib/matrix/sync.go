@@ -0,0 +1,38 @@
+package matrix
+
+import (
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+// receiveTimelineLimit is the maximal number of messages synced per room when the session receives messages
+const receiveTimelineLimit = 50
+
+var allEventTypes = []event.Type{{Type: "*"}}
+
+// the sync loop serves the encryption, room members are fetched explicitly before sending, the room messages are
+// only synced when the session receives them
+func syncFilter(receiveMessages bool) *mautrix.Filter {
+	timeline := &mautrix.FilterPart{NotTypes: allEventTypes, LazyLoadMembers: true}
+	if receiveMessages {
+		timeline = &mautrix.FilterPart{
+			Types:           []event.Type{event.EventMessage, event.EventEncrypted},
+			Limit:           receiveTimelineLimit,
+			LazyLoadMembers: true,
+		}
+	}
+
+	return &mautrix.Filter{
+		AccountData: &mautrix.FilterPart{NotTypes: allEventTypes},
+		Presence:    &mautrix.FilterPart{NotTypes: allEventTypes},
+		Room: &mautrix.RoomFilter{
+			AccountData: &mautrix.FilterPart{NotTypes: allEventTypes},
+			Ephemeral:   &mautrix.FilterPart{NotTypes: allEventTypes},
+			State: &mautrix.FilterPart{
+				Types:           []event.Type{event.StateMember, event.StateEncryption},
+				LazyLoadMembers: true,
+			},
+			Timeline: timeline,
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package matrix
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type syncFilterJSON struct {
+	Presence struct {
+		NotTypes []string `json:"not_types"`
+	} `json:"presence"`
+	Room struct {
+		State struct {
+			Types           []string `json:"types"`
+			LazyLoadMembers bool     `json:"lazy_load_members"`
+		} `json:"state"`
+		Timeline struct {
+			Types           []string `json:"types"`
+			NotTypes        []string `json:"not_types"`
+			Limit           int      `json:"limit"`
+			LazyLoadMembers bool     `json:"lazy_load_members"`
+		} `json:"timeline"`
+	} `json:"room"`
+}
+
+func TestSyncFilter(t *testing.T) {
+	filter := marshalSyncFilter(t, false)
+
+	if len(filter.Presence.NotTypes) != 1 || filter.Presence.NotTypes[0] != "*" {
+		t.Fatalf("expected presence to be dropped, got %v", filter.Presence.NotTypes)
+	}
+	if len(filter.Room.Timeline.NotTypes) != 1 || filter.Room.Timeline.NotTypes[0] != "*" || len(filter.Room.Timeline.Types) != 0 {
+		t.Fatalf("expected timeline to be dropped, got %v", filter.Room.Timeline)
+	}
+	if !filter.Room.State.LazyLoadMembers || !filter.Room.Timeline.LazyLoadMembers {
+		t.Fatalf("expected members to be lazy loaded, got %v", filter.Room)
+	}
+	if len(filter.Room.State.Types) != 2 {
+		t.Fatalf("expected state to be limited to membership and encryption, got %v", filter.Room.State.Types)
+	}
+}
+
+func TestSyncFilterReceivingMessages(t *testing.T) {
+	filter := marshalSyncFilter(t, true)
+
+	if len(filter.Room.Timeline.Types) != 2 || filter.Room.Timeline.Types[0] != "m.room.message" || filter.Room.Timeline.Types[1] != "m.room.encrypted" {
+		t.Fatalf("expected timeline to be limited to messages, got %v", filter.Room.Timeline.Types)
+	}
+	if len(filter.Room.Timeline.NotTypes) != 0 {
+		t.Fatalf("expected no excluded timeline types, got %v", filter.Room.Timeline.NotTypes)
+	}
+	if filter.Room.Timeline.Limit != receiveTimelineLimit {
+		t.Fatalf("expected timeline limit %d, got %d", receiveTimelineLimit, filter.Room.Timeline.Limit)
+	}
+	if len(filter.Room.State.Types) != 2 {
+		t.Fatalf("expected state to be limited to membership and encryption, got %v", filter.Room.State.Types)
+	}
+}
+
+func marshalSyncFilter(t *testing.T, receiveMessages bool) (filter syncFilterJSON) {
+	raw, err := json.Marshal(syncFilter(receiveMessages))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := json.Unmarshal(raw, &filter); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	return
+}
@@ -0,0 +1,259 @@
+package matrix
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+)
+
+type DeviceTrustMode string
+
+const (
+	// DeviceTrustAll shares the room keys with every device which is not blacklisted
+	DeviceTrustAll DeviceTrustMode = "all"
+	// DeviceTrustCrossSigned shares the room keys only with cross-signed devices of users verified by the notifier account
+	DeviceTrustCrossSigned DeviceTrustMode = "cross_signed"
+	// DeviceTrustTofu shares the room keys with cross-signed devices as long as the user's master key doesn't change
+	DeviceTrustTofu DeviceTrustMode = "tofu"
+)
+
+const (
+	WithheldReasonBlacklisted = "blacklisted"
+	WithheldReasonUnverified  = "unverified"
+	WithheldReasonKeyChanged  = "key_changed"
+)
+
+type WithheldDevice struct {
+	UserId   id.UserID
+	DeviceId id.DeviceID
+	Trust    id.TrustState
+	Reason   string
+}
+
+type sharingKeysContextKey struct{}
+
+type withheldRoom struct {
+	sessionId id.SessionID
+	devices   []WithheldDevice
+}
+
+func (mode DeviceTrustMode) minTrust() (id.TrustState, error) {
+	switch mode {
+	case "", DeviceTrustAll:
+		return id.TrustStateUnset, nil
+	case DeviceTrustCrossSigned:
+		return id.TrustStateCrossSignedVerified, nil
+	case DeviceTrustTofu:
+		return id.TrustStateCrossSignedTOFU, nil
+	default:
+		return id.TrustStateUnset, newError(ErrorCategoryInvalidRequest, fmt.Errorf("unsupported device trust mode: %s", mode))
+	}
+}
+
+// trustStore hides the cross-signing keys of the users below the minimal trust while the room keys are being shared,
+// so that the olm machine treats their devices as not cross-signed and withholds the keys from them.
+// The trust is resolved here because the olm machine reports devices of unverified users as verified.
+type trustStore struct {
+	crypto.Store
+	ownUserId id.UserID
+	minTrust  id.TrustState
+
+	// the room keys are only shared again with a new outbound session, so the withheld devices are kept until then
+	withheld     map[id.RoomID]withheldRoom
+	withheldLock sync.Mutex
+}
+
+// withSharingKeys marks the context used to share the room keys, the olm machine looks up the trust of the devices with it
+func withSharingKeys(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sharingKeysContextKey{}, true)
+}
+
+func (receiver *trustStore) GetCrossSigningKeys(ctx context.Context, userId id.UserID) (map[id.CrossSigningUsage]id.CrossSigningKey, error) {
+	keys, err := receiver.Store.GetCrossSigningKeys(ctx, userId)
+	if err != nil || userId == receiver.ownUserId || ctx.Value(sharingKeysContextKey{}) == nil {
+		return keys, err
+	}
+
+	trust, err := receiver.userTrust(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if trust < receiver.minTrust {
+		return nil, nil
+	}
+
+	return keys, nil
+}
+
+func (receiver *trustStore) userTrust(ctx context.Context, userId id.UserID) (id.TrustState, error) {
+	theirKeys, err := receiver.Store.GetCrossSigningKeys(ctx, userId)
+	if err != nil {
+		return id.TrustStateUnset, err
+	}
+	theirMasterKey, ok := theirKeys[id.XSUsageMaster]
+	if !ok {
+		return id.TrustStateUnset, nil
+	}
+	if userId == receiver.ownUserId {
+		return id.TrustStateCrossSignedVerified, nil
+	}
+
+	ownKeys, err := receiver.Store.GetCrossSigningKeys(ctx, receiver.ownUserId)
+	if err != nil {
+		return id.TrustStateUnset, err
+	}
+	ownMasterKey, hasMasterKey := ownKeys[id.XSUsageMaster]
+	ownUserSigningKey, hasUserSigningKey := ownKeys[id.XSUsageUserSigning]
+	if hasMasterKey && hasUserSigningKey {
+		verified, err := receiver.isSignedBy(ctx, receiver.ownUserId, ownUserSigningKey.Key, receiver.ownUserId, ownMasterKey.Key)
+		if err == nil && verified {
+			verified, err = receiver.isSignedBy(ctx, userId, theirMasterKey.Key, receiver.ownUserId, ownUserSigningKey.Key)
+		}
+		if err != nil {
+			return id.TrustStateUnset, err
+		}
+		if verified {
+			return id.TrustStateCrossSignedVerified, nil
+		}
+	}
+
+	if theirMasterKey.Key == theirMasterKey.First {
+		return id.TrustStateCrossSignedTOFU, nil
+	}
+
+	return id.TrustStateCrossSignedUntrusted, nil
+}
+
+func (receiver *trustStore) deviceTrust(ctx context.Context, device *id.Device) (id.TrustState, error) {
+	if device.Trust == id.TrustStateVerified || device.Trust == id.TrustStateBlacklisted {
+		return device.Trust, nil
+	}
+
+	keys, err := receiver.Store.GetCrossSigningKeys(ctx, device.UserID)
+	if err != nil {
+		return id.TrustStateUnset, err
+	}
+	masterKey, hasMasterKey := keys[id.XSUsageMaster]
+	selfSigningKey, hasSelfSigningKey := keys[id.XSUsageSelfSigning]
+	if !hasMasterKey || !hasSelfSigningKey {
+		return id.TrustStateUnset, nil
+	}
+
+	signed, err := receiver.isSignedBy(ctx, device.UserID, selfSigningKey.Key, device.UserID, masterKey.Key)
+	if err == nil && signed {
+		signed, err = receiver.isSignedBy(ctx, device.UserID, device.SigningKey, device.UserID, selfSigningKey.Key)
+	}
+	if err != nil || !signed {
+		return id.TrustStateUnset, err
+	}
+
+	return receiver.userTrust(ctx, device.UserID)
+}
+
+func (receiver *trustStore) isSignedBy(ctx context.Context, userId id.UserID, key id.Ed25519, signerId id.UserID, signerKey id.Ed25519) (bool, error) {
+	return receiver.Store.IsKeySignedBy(ctx, userId, key, signerId, signerKey)
+}
+
+func withheldReason(trust id.TrustState, minTrust id.TrustState) (reason string, withheld bool) {
+	switch {
+	case trust == id.TrustStateBlacklisted:
+		return WithheldReasonBlacklisted, true
+	case trust >= minTrust:
+		return "", false
+	case trust == id.TrustStateCrossSignedUntrusted:
+		return WithheldReasonKeyChanged, true
+	default:
+		return WithheldReasonUnverified, true
+	}
+}
+
+// withheldDevices returns the devices in the room the room keys are not shared with
+func (receiver *trustStore) withheldDevices(ctx context.Context, client *mautrix.Client, roomId id.RoomID) (withheld []WithheldDevice, err error) {
+	encrypted, err := client.StateStore.IsEncrypted(ctx, roomId)
+	if err != nil || !encrypted {
+		return
+	}
+
+	outbound, err := receiver.Store.GetOutboundGroupSession(ctx, roomId)
+	if err != nil {
+		return
+	}
+	if outbound != nil {
+		if cached, ok := receiver.cachedWithheld(roomId, outbound.ID()); ok {
+			return cached, nil
+		}
+		defer func() {
+			if err == nil {
+				receiver.cacheWithheld(roomId, outbound.ID(), withheld)
+			}
+		}()
+	}
+
+	members, err := client.StateStore.GetRoomJoinedOrInvitedMembers(ctx, roomId)
+	if err != nil {
+		return
+	}
+
+	for _, userId := range members {
+		var devices map[id.DeviceID]*id.Device
+		devices, err = receiver.Store.GetDevices(ctx, userId)
+		if err != nil {
+			return
+		}
+
+		for deviceId, device := range devices {
+			if userId == client.UserID && deviceId == client.DeviceID {
+				continue
+			}
+
+			var trust id.TrustState
+			trust, err = receiver.deviceTrust(ctx, device)
+			if err != nil {
+				return
+			}
+
+			if reason, ok := withheldReason(trust, receiver.minTrust); ok {
+				withheld = append(withheld, WithheldDevice{
+					UserId:   userId,
+					DeviceId: deviceId,
+					Trust:    trust,
+					Reason:   reason,
+				})
+			}
+		}
+	}
+
+	slices.SortFunc(withheld, func(a, b WithheldDevice) int {
+		return cmp.Or(cmp.Compare(a.UserId, b.UserId), cmp.Compare(a.DeviceId, b.DeviceId))
+	})
+
+	return
+}
+
+func (receiver *trustStore) cachedWithheld(roomId id.RoomID, sessionId id.SessionID) ([]WithheldDevice, bool) {
+	receiver.withheldLock.Lock()
+	defer receiver.withheldLock.Unlock()
+
+	cached, ok := receiver.withheld[roomId]
+	if !ok || cached.sessionId != sessionId {
+		return nil, false
+	}
+
+	return slices.Clone(cached.devices), true
+}
+
+func (receiver *trustStore) cacheWithheld(roomId id.RoomID, sessionId id.SessionID, devices []WithheldDevice) {
+	receiver.withheldLock.Lock()
+	defer receiver.withheldLock.Unlock()
+
+	if receiver.withheld == nil {
+		receiver.withheld = make(map[id.RoomID]withheldRoom)
+	}
+	receiver.withheld[roomId] = withheldRoom{sessionId: sessionId, devices: slices.Clone(devices)}
+}
@@ -0,0 +1,247 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	trustOwnUser   id.UserID = "@bot:example.org"
+	trustVerified  id.UserID = "@alice:example.org"
+	trustFirstSeen id.UserID = "@bob:example.org"
+	trustChanged   id.UserID = "@carol:example.org"
+	trustUnsigned  id.UserID = "@dave:example.org"
+)
+
+func putCrossSignedUser(t *testing.T, store *crypto.MemoryStore, userId id.UserID, deviceId id.DeviceID) {
+	ctx := context.Background()
+	masterKey := id.Ed25519(userId + "-master")
+	selfSigningKey := id.Ed25519(userId + "-self")
+	deviceKey := id.Ed25519(userId + "-device")
+
+	if err := store.PutCrossSigningKey(ctx, userId, id.XSUsageMaster, masterKey); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := store.PutCrossSigningKey(ctx, userId, id.XSUsageSelfSigning, selfSigningKey); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := store.PutSignature(ctx, userId, selfSigningKey, userId, masterKey, "signature"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := store.PutSignature(ctx, userId, deviceKey, userId, selfSigningKey, "signature"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := store.PutDevices(ctx, userId, map[id.DeviceID]*id.Device{
+		deviceId: {UserID: userId, DeviceID: deviceId, SigningKey: deviceKey},
+	}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func newTestTrustStore(t *testing.T, minTrust id.TrustState) *trustStore {
+	ctx := context.Background()
+	store := crypto.NewMemoryStore(nil)
+
+	putCrossSignedUser(t, store, trustOwnUser, "BOT")
+	ownMasterKey := id.Ed25519(trustOwnUser + "-master")
+	ownUserSigningKey := id.Ed25519(trustOwnUser + "-user")
+	_ = store.PutCrossSigningKey(ctx, trustOwnUser, id.XSUsageUserSigning, ownUserSigningKey)
+	_ = store.PutSignature(ctx, trustOwnUser, ownUserSigningKey, trustOwnUser, ownMasterKey, "signature")
+
+	putCrossSignedUser(t, store, trustVerified, "ALICE")
+	_ = store.PutSignature(ctx, trustVerified, id.Ed25519(trustVerified+"-master"), trustOwnUser, ownUserSigningKey, "signature")
+
+	putCrossSignedUser(t, store, trustFirstSeen, "BOB")
+
+	putCrossSignedUser(t, store, trustChanged, "CAROL")
+	_ = store.PutCrossSigningKey(ctx, trustChanged, id.XSUsageMaster, "rotated-master")
+
+	_ = store.PutDevices(ctx, trustUnsigned, map[id.DeviceID]*id.Device{
+		"DAVE":    {UserID: trustUnsigned, DeviceID: "DAVE", SigningKey: "dave-device"},
+		"BLOCKED": {UserID: trustUnsigned, DeviceID: "BLOCKED", SigningKey: "dave-blocked", Trust: id.TrustStateBlacklisted},
+	})
+
+	return &trustStore{Store: store, ownUserId: trustOwnUser, minTrust: minTrust}
+}
+
+func TestDeviceTrustModeMinTrust(t *testing.T) {
+	cases := map[DeviceTrustMode]id.TrustState{
+		"":                     id.TrustStateUnset,
+		DeviceTrustAll:         id.TrustStateUnset,
+		DeviceTrustCrossSigned: id.TrustStateCrossSignedVerified,
+		DeviceTrustTofu:        id.TrustStateCrossSignedTOFU,
+	}
+
+	for mode, expected := range cases {
+		minTrust, err := mode.minTrust()
+		if err != nil {
+			t.Fatalf("expected no error for %q, got %v", mode, err)
+		}
+		if minTrust != expected {
+			t.Fatalf("expected %s for %q, got %s", expected, mode, minTrust)
+		}
+	}
+
+	_, err := DeviceTrustMode("everyone").minTrust()
+	var matrixErr *Error
+	if !errors.As(err, &matrixErr) || matrixErr.Category != ErrorCategoryInvalidRequest {
+		t.Fatalf("expected an invalid request error, got %v", err)
+	}
+}
+
+func TestTrustStoreDeviceTrust(t *testing.T) {
+	ctx := context.Background()
+	trust := newTestTrustStore(t, id.TrustStateCrossSignedVerified)
+
+	cases := []struct {
+		userId   id.UserID
+		deviceId id.DeviceID
+		expected id.TrustState
+	}{
+		{trustVerified, "ALICE", id.TrustStateCrossSignedVerified},
+		{trustFirstSeen, "BOB", id.TrustStateCrossSignedTOFU},
+		{trustChanged, "CAROL", id.TrustStateUnset},
+		{trustUnsigned, "DAVE", id.TrustStateUnset},
+		{trustUnsigned, "BLOCKED", id.TrustStateBlacklisted},
+	}
+
+	for _, testCase := range cases {
+		device, err := trust.Store.GetDevice(ctx, testCase.userId, testCase.deviceId)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		actual, err := trust.deviceTrust(ctx, device)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if actual != testCase.expected {
+			t.Fatalf("expected %s for %s, got %s", testCase.expected, testCase.deviceId, actual)
+		}
+	}
+
+	userTrust, err := trust.userTrust(ctx, trustChanged)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if userTrust != id.TrustStateCrossSignedUntrusted {
+		t.Fatalf("expected the changed master key to be untrusted, got %s", userTrust)
+	}
+}
+
+func TestTrustStoreHidesKeysOnlyWhileSharing(t *testing.T) {
+	ctx := context.Background()
+	trust := newTestTrustStore(t, id.TrustStateCrossSignedVerified)
+
+	keys, err := trust.GetCrossSigningKeys(ctx, trustFirstSeen)
+	if err != nil || len(keys) == 0 {
+		t.Fatalf("expected the keys outside of sharing, got %v, %v", keys, err)
+	}
+
+	keys, err = trust.GetCrossSigningKeys(withSharingKeys(ctx), trustFirstSeen)
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("expected the keys to be hidden while sharing, got %v, %v", keys, err)
+	}
+
+	keys, err = trust.GetCrossSigningKeys(withSharingKeys(ctx), trustVerified)
+	if err != nil || len(keys) == 0 {
+		t.Fatalf("expected the keys of the verified user while sharing, got %v, %v", keys, err)
+	}
+
+	keys, err = trust.GetCrossSigningKeys(withSharingKeys(ctx), trustOwnUser)
+	if err != nil || len(keys) == 0 {
+		t.Fatalf("expected the own keys while sharing, got %v, %v", keys, err)
+	}
+}
+
+func TestTrustStoreWithheldDevices(t *testing.T) {
+	ctx := context.Background()
+	roomId := id.RoomID("!room:example.org")
+
+	client, err := mautrix.NewClient("https://example.org", trustOwnUser, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	client.DeviceID = "BOT"
+	client.StateStore = mautrix.NewMemoryStateStore()
+	_ = client.StateStore.SetEncryptionEvent(ctx, roomId, &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1})
+	for _, userId := range []id.UserID{trustOwnUser, trustVerified, trustFirstSeen, trustChanged, trustUnsigned} {
+		_ = client.StateStore.SetMembership(ctx, roomId, userId, event.MembershipJoin)
+	}
+
+	cases := map[id.TrustState][]WithheldDevice{
+		id.TrustStateCrossSignedVerified: {
+			{UserId: trustFirstSeen, DeviceId: "BOB", Trust: id.TrustStateCrossSignedTOFU, Reason: WithheldReasonUnverified},
+			{UserId: trustChanged, DeviceId: "CAROL", Trust: id.TrustStateUnset, Reason: WithheldReasonUnverified},
+			{UserId: trustUnsigned, DeviceId: "BLOCKED", Trust: id.TrustStateBlacklisted, Reason: WithheldReasonBlacklisted},
+			{UserId: trustUnsigned, DeviceId: "DAVE", Trust: id.TrustStateUnset, Reason: WithheldReasonUnverified},
+		},
+		id.TrustStateUnset: {
+			{UserId: trustUnsigned, DeviceId: "BLOCKED", Trust: id.TrustStateBlacklisted, Reason: WithheldReasonBlacklisted},
+		},
+	}
+
+	for minTrust, expected := range cases {
+		withheld, err := newTestTrustStore(t, minTrust).withheldDevices(ctx, client, roomId)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(withheld) != len(expected) {
+			t.Fatalf("expected %d withheld devices with %s, got %v", len(expected), minTrust, withheld)
+		}
+		for i := range expected {
+			if withheld[i] != expected[i] {
+				t.Fatalf("expected %v, got %v", expected[i], withheld[i])
+			}
+		}
+	}
+}
+
+func TestTrustStoreWithheldDevicesKeptPerOutboundSession(t *testing.T) {
+	ctx := context.Background()
+	roomId := id.RoomID("!room:example.org")
+
+	client, err := mautrix.NewClient("https://example.org", trustOwnUser, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	client.DeviceID = "BOT"
+	client.StateStore = mautrix.NewMemoryStateStore()
+	_ = client.StateStore.SetEncryptionEvent(ctx, roomId, &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1})
+	_ = client.StateStore.SetMembership(ctx, roomId, trustUnsigned, event.MembershipJoin)
+
+	trust := newTestTrustStore(t, id.TrustStateUnset)
+	shareSession := func() {
+		session, err := crypto.NewOutboundGroupSession(roomId, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := trust.Store.AddOutboundGroupSession(ctx, session); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	shareSession()
+
+	withheld, err := trust.withheldDevices(ctx, client, roomId)
+	if err != nil || len(withheld) != 1 {
+		t.Fatalf("expected one withheld device, got %v, %v", withheld, err)
+	}
+
+	_ = trust.Store.PutDevice(ctx, trustUnsigned, &id.Device{UserID: trustUnsigned, DeviceID: "DAVE", SigningKey: "dave-device", Trust: id.TrustStateBlacklisted})
+	withheld, err = trust.withheldDevices(ctx, client, roomId)
+	if err != nil || len(withheld) != 1 {
+		t.Fatalf("expected the withheld devices of the same session, got %v, %v", withheld, err)
+	}
+
+	shareSession()
+	withheld, err = trust.withheldDevices(ctx, client, roomId)
+	if err != nil || len(withheld) != 2 {
+		t.Fatalf("expected the withheld devices of the new session, got %v, %v", withheld, err)
+	}
+}
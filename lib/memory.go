@@ -0,0 +1,117 @@
+package main
+
+// #include <stdlib.h>
+import "C"
+import (
+	"encoding/json"
+	"lib/api"
+	"sync"
+	"unsafe"
+)
+
+// maxErrorDetails bounds the details kept for the error messages the host hasn't freed, the oldest ones are dropped
+const maxErrorDetails = 1024
+
+type errorDetailsEntry struct {
+	details  *api.Error
+	sequence uint64
+}
+
+var (
+	errorDetails         = map[unsafe.Pointer]errorDetailsEntry{}
+	errorDetailsSequence uint64
+	errorDetailsLock     sync.Mutex
+)
+
+// FreeString frees a string returned by the library, it's mandatory for the error messages too, their details
+// are kept until then
+//
+//export FreeString
+func FreeString(value *C.char) {
+	if value == nil {
+		return
+	}
+
+	errorDetailsLock.Lock()
+	delete(errorDetails, unsafe.Pointer(value))
+	errorDetailsLock.Unlock()
+
+	C.free(unsafe.Pointer(value))
+}
+
+//export FreeResult
+func FreeResult(result *C.char, err *C.char) {
+	FreeString(result)
+	FreeString(err)
+}
+
+// ErrorDetails returns the error category, Matrix errcode and HTTP status of an error message returned
+// through an err out-pointer as JSON, the details can be read only once and until the error message is freed,
+// so that a stale entry is never returned for a reused address. Only the details of the last 1024 error messages
+// which weren't freed are kept
+//
+//export ErrorDetails
+func ErrorDetails(err *C.char) *C.char {
+	errorDetailsLock.Lock()
+	entry, ok := errorDetails[unsafe.Pointer(err)]
+	delete(errorDetails, unsafe.Pointer(err))
+	errorDetailsLock.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	serialized, encodeErr := json.Marshal(entry.details)
+	if encodeErr != nil {
+		return nil
+	}
+
+	return C.CString(string(serialized))
+}
+
+func initOutPointers(pointers ...**C.char) {
+	for _, pointer := range pointers {
+		if pointer != nil {
+			*pointer = nil
+		}
+	}
+}
+
+func setString(target **C.char, value string) {
+	if target != nil {
+		*target = C.CString(value)
+	}
+}
+
+func setError(target **C.char, err error) {
+	if target == nil {
+		return
+	}
+
+	setString(target, err.Error())
+
+	errorDetailsLock.Lock()
+	defer errorDetailsLock.Unlock()
+
+	if len(errorDetails) >= maxErrorDetails {
+		dropOldestErrorDetails()
+	}
+	errorDetailsSequence++
+	errorDetails[unsafe.Pointer(*target)] = errorDetailsEntry{
+		details:  api.NewError(api.ErrorCodeOperationFailed, err),
+		sequence: errorDetailsSequence,
+	}
+}
+
+// dropOldestErrorDetails expects the lock to be held, it only runs when the host doesn't free the error messages
+func dropOldestErrorDetails() {
+	var oldest unsafe.Pointer
+	var oldestSequence uint64
+	for pointer, entry := range errorDetails {
+		if oldest == nil || entry.sequence < oldestSequence {
+			oldest, oldestSequence = pointer, entry.sequence
+		}
+	}
+
+	delete(errorDetails, oldest)
+}
@@ -1,2 +1,23 @@
-extern char* SendMessage(char* messageType, char* renderingType, char* message, char* recipient, char* databaseDsn, char* accessToken, char* recoveryKey, char* pickleKey, char* url, char* deviceId, char** err);
-extern void Login(char* homeserver, char* username, char* password, char** err, char** deviceId, char** accessToken);
+extern char* SendMessage(char* messageType, char* renderingType, char* message, char* recipients, char* databaseDsn, char* accessToken, char* recoveryKey, char* pickleKey, char* url, char* deviceId, char* idempotencyKey, long long int timeoutMs, char** err);
+extern char* SendAttachment(char* messageType, char* fileName, char* mimeType, char* data, int dataLength, char* recipient, char* databaseDsn, char* accessToken, char* recoveryKey, char* pickleKey, char* url, char* deviceId, char* idempotencyKey, long long int timeoutMs, char** err);
+extern char* EditMessage(char* messageType, char* renderingType, char* message, char* recipient, char* eventId, char* databaseDsn, char* accessToken, char* recoveryKey, char* pickleKey, char* url, char* deviceId, long long int timeoutMs, char** err);
+extern char* RedactMessage(char* recipient, char* eventId, char* reason, char* databaseDsn, char* accessToken, char* recoveryKey, char* pickleKey, char* url, char* deviceId, long long int timeoutMs, char** err);
+extern char* React(char* recipient, char* eventId, char* key, char* databaseDsn, char* accessToken, char* recoveryKey, char* pickleKey, char* url, char* deviceId, long long int timeoutMs, char** err);
+extern char* Unreact(char* recipient, char* eventId, char* key, char* databaseDsn, char* accessToken, char* recoveryKey, char* pickleKey, char* url, char* deviceId, long long int timeoutMs, char** err);
+extern char* ExportRoomKeys(char* databaseDsn, char* accessToken, char* pickleKey, char* url, char* deviceId, char* passphrase, long long int timeoutMs, char** err);
+extern char* ImportRoomKeys(char* databaseDsn, char* accessToken, char* pickleKey, char* url, char* deviceId, char* passphrase, char* export, long long int timeoutMs, char** err);
+extern char* Execute(char* request);
+extern void Login(char* homeserver, char* username, char* password, long long int timeoutMs, char** err, char** deviceId, char** accessToken);
+extern long long unsigned int OpenSession(char* databaseDsn, char* accessToken, char* recoveryKey, char* pickleKey, char* url, char* deviceId, char* inviteUserIds, char* inviteServers, char* inviteRoomPatterns, char* deviceTrust, int receiveMessages, long long int timeoutMs, char** err);
+extern char* SessionSend(long long unsigned int handle, char* messageType, char* renderingType, char* message, char* recipient, char* threadRootEventId, char* replyToEventId, char* mentionUserIds, int mentionRoom, char* idempotencyKey, long long int timeoutMs, char** err);
+extern char* SessionSendAttachment(long long unsigned int handle, char* messageType, char* fileName, char* mimeType, char* data, int dataLength, char* recipient, char* threadRootEventId, char* replyToEventId, char* mentionUserIds, int mentionRoom, char* idempotencyKey, long long int timeoutMs, char** err);
+extern char* SessionEdit(long long unsigned int handle, char* messageType, char* renderingType, char* message, char* recipient, char* eventId, long long int timeoutMs, char** err);
+extern char* SessionRedact(long long unsigned int handle, char* recipient, char* eventId, char* reason, long long int timeoutMs, char** err);
+extern char* SessionReact(long long unsigned int handle, char* recipient, char* eventId, char* key, long long int timeoutMs, char** err);
+extern char* SessionUnreact(long long unsigned int handle, char* recipient, char* eventId, char* key, long long int timeoutMs, char** err);
+extern char* SessionFetchEvents(long long unsigned int handle, long long unsigned int since, char** err);
+extern char* SessionInviteDecisions(long long unsigned int handle, char** err);
+extern void CloseSession(long long unsigned int handle, char** err);
+extern void FreeString(char* value);
+extern void FreeResult(char* result, char* err);
+extern char* ErrorDetails(char* err);
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"lib/api"
+	"lib/matrix"
+	"lib/types"
+	"net/http"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	ErrorCodeUnauthorized    api.ErrorCode = "unauthorized"
+	ErrorCodeQueueFull       api.ErrorCode = "queue_full"
+	ErrorCodeRequestTooLarge api.ErrorCode = "request_too_large"
+)
+
+// MaxRequestSize is the maximal size of the send request body in bytes
+const MaxRequestSize = 1 << 20
+
+var ErrQueueFull = errors.New("the send queue is full")
+
+type Sender interface {
+	SendMessageToRecipients(
+		ctx context.Context,
+		messageType types.MessageType,
+		renderingType types.RenderingType,
+		message string,
+		recipients []string,
+		options *matrix.MessageOptions,
+	) (map[string]matrix.RecipientResult, error)
+	Health() matrix.SessionHealth
+}
+
+type SendRequest struct {
+	Recipient      string              `json:"recipient"`
+	MessageType    types.MessageType   `json:"message_type"`
+	RenderingType  types.RenderingType `json:"rendering_type"`
+	Body           string              `json:"body"`
+	IdempotencyKey string              `json:"idempotency_key"`
+}
+
+type SendResponse struct {
+	Results []api.Result `json:"results,omitempty"`
+	Error   *api.Error   `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status           string              `json:"status"`
+	Syncing          bool                `json:"syncing"`
+	CryptoReady      bool                `json:"crypto_ready"`
+	KeyBackupVersion id.KeyBackupVersion `json:"key_backup_version,omitempty"`
+	QueueLength      int                 `json:"queue_length"`
+	QueueCapacity    int                 `json:"queue_capacity"`
+	Error            string              `json:"error,omitempty"`
+}
+
+type job struct {
+	ctx        context.Context
+	request    SendRequest
+	recipients []string
+	response   chan SendResponse
+}
+
+type Server struct {
+	sender    Sender
+	authToken string
+	queue     chan job
+	mux       *http.ServeMux
+}
+
+// NewServer creates a server which sends the messages one by one, an empty auth token disables the authentication
+func NewServer(sender Sender, authToken string, queueSize int) *Server {
+	server := &Server{
+		sender:    sender,
+		authToken: authToken,
+		queue:     make(chan job, queueSize),
+		mux:       http.NewServeMux(),
+	}
+	server.mux.HandleFunc("POST /send", server.handleSend)
+	server.mux.HandleFunc("GET /healthz", server.handleHealth)
+
+	return server
+}
+
+func (receiver *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	receiver.mux.ServeHTTP(w, r)
+}
+
+// Run processes the queue until the context is cancelled
+func (receiver *Server) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case queued := <-receiver.queue:
+			queued.response <- receiver.process(queued)
+		}
+	}
+}
+
+func (receiver *Server) process(queued job) SendResponse {
+	if err := queued.ctx.Err(); err != nil {
+		return SendResponse{Error: api.NewError(api.ErrorCodeOperationFailed, err)}
+	}
+
+	request := queued.request
+	results, err := receiver.sender.SendMessageToRecipients(
+		queued.ctx,
+		request.MessageType,
+		request.RenderingType,
+		request.Body,
+		queued.recipients,
+		&matrix.MessageOptions{IdempotencyKey: request.IdempotencyKey},
+	)
+	if err != nil {
+		return SendResponse{Error: api.NewError(api.ErrorCodeOperationFailed, err)}
+	}
+
+	var response SendResponse
+	for _, recipient := range queued.recipients {
+		result, ok := results[recipient]
+		if !ok {
+			continue
+		}
+		delete(results, recipient)
+
+		response.Results = append(response.Results, api.NewResult(recipient, result))
+	}
+
+	return response
+}
+
+func (receiver *Server) handleSend(w http.ResponseWriter, r *http.Request) {
+	if !receiver.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeResponse(w, http.StatusUnauthorized, SendResponse{
+			Error: api.NewError(ErrorCodeUnauthorized, errors.New("missing or invalid bearer token")),
+		})
+		return
+	}
+
+	var request SendRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxRequestSize)).Decode(&request); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeResponse(w, http.StatusRequestEntityTooLarge, SendResponse{Error: api.NewError(ErrorCodeRequestTooLarge, err)})
+			return
+		}
+		writeResponse(w, http.StatusBadRequest, SendResponse{Error: api.NewError(api.ErrorCodeInvalidRequest, err)})
+		return
+	}
+	if request.MessageType == "" {
+		request.MessageType = types.MessageTypeTextMessage
+	}
+	if request.RenderingType == "" {
+		request.RenderingType = types.RenderingTypePlainText
+	}
+	if request.MessageType.IsAttachment() {
+		writeResponse(w, http.StatusBadRequest, SendResponse{Error: api.NewError(
+			api.ErrorCodeInvalidRequest,
+			errors.New("attachments cannot be sent through the relay"),
+		)})
+		return
+	}
+
+	recipients := matrix.SplitList(request.Recipient)
+	if len(recipients) == 0 {
+		writeResponse(w, http.StatusBadRequest, SendResponse{Error: api.NewError(api.ErrorCodeInvalidRequest, matrix.ErrNoRecipients)})
+		return
+	}
+
+	queued := job{
+		ctx:        r.Context(),
+		request:    request,
+		recipients: recipients,
+		response:   make(chan SendResponse, 1),
+	}
+	select {
+	case receiver.queue <- queued:
+	default:
+		w.Header().Set("Retry-After", "1")
+		writeResponse(w, http.StatusServiceUnavailable, SendResponse{Error: &api.Error{
+			Code:      ErrorCodeQueueFull,
+			Message:   ErrQueueFull.Error(),
+			Retryable: true,
+		}})
+		return
+	}
+
+	select {
+	case response := <-queued.response:
+		writeResponse(w, sendStatus(response), response)
+	case <-r.Context().Done():
+	}
+}
+
+func (receiver *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	health := receiver.sender.Health()
+	response := HealthResponse{
+		Status:           "ok",
+		Syncing:          health.Syncing,
+		CryptoReady:      health.CryptoReady,
+		KeyBackupVersion: health.KeyBackupVersion,
+		QueueLength:      len(receiver.queue),
+		QueueCapacity:    cap(receiver.queue),
+	}
+	if health.SyncErr != nil {
+		response.Error = health.SyncErr.Error()
+	}
+
+	status := http.StatusOK
+	if !health.Syncing || !health.CryptoReady {
+		response.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	writeResponse(w, status, response)
+}
+
+func (receiver *Server) authorized(r *http.Request) bool {
+	if receiver.authToken == "" {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(receiver.authToken)) == 1
+}
+
+// sendStatus reports a failure only if nothing was delivered, so that callers retrying on errors don't
+// duplicate the messages which were already sent
+func sendStatus(response SendResponse) int {
+	if response.Error != nil {
+		if response.Error.Code == api.ErrorCodeInvalidRequest {
+			return http.StatusBadRequest
+		}
+		return http.StatusBadGateway
+	}
+
+	for _, result := range response.Results {
+		if result.Error == nil {
+			return http.StatusOK
+		}
+	}
+
+	return http.StatusBadGateway
+}
+
+func writeResponse(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
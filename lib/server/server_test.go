@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"lib/matrix"
+	"lib/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+type fakeSender struct {
+	health  matrix.SessionHealth
+	block   chan struct{}
+	started chan struct{}
+	sent    []string
+	options *matrix.MessageOptions
+}
+
+func (receiver *fakeSender) SendMessageToRecipients(
+	ctx context.Context,
+	messageType types.MessageType,
+	renderingType types.RenderingType,
+	message string,
+	recipients []string,
+	options *matrix.MessageOptions,
+) (map[string]matrix.RecipientResult, error) {
+	if receiver.started != nil {
+		select {
+		case receiver.started <- struct{}{}:
+		default:
+		}
+	}
+	if receiver.block != nil {
+		<-receiver.block
+	}
+
+	receiver.sent = append(receiver.sent, message)
+	receiver.options = options
+
+	results := make(map[string]matrix.RecipientResult)
+	for _, recipient := range recipients {
+		if recipient == "@broken:example.org" {
+			results[recipient] = matrix.RecipientResult{Err: errors.New("broken")}
+			continue
+		}
+		results[recipient] = matrix.RecipientResult{RoomId: id.RoomID("!room:example.org"), EventId: "$event", Attempts: 1}
+	}
+
+	return results, nil
+}
+
+func (receiver *fakeSender) Health() matrix.SessionHealth {
+	return receiver.health
+}
+
+func startServer(t *testing.T, sender Sender, queueSize int) *httptest.Server {
+	t.Helper()
+
+	server, _ := startRelay(t, sender, queueSize)
+
+	return server
+}
+
+func startRelay(t *testing.T, sender Sender, queueSize int) (*httptest.Server, *Server) {
+	t.Helper()
+
+	relay := NewServer(sender, "secret", queueSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go relay.Run(ctx)
+
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+
+	return server, relay
+}
+
+func post(t *testing.T, server *httptest.Server, token string, body string) (*http.Response, SendResponse) {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+"/send", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer response.Body.Close()
+
+	var decoded SendResponse
+	if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	return response, decoded
+}
+
+func TestSend(t *testing.T) {
+	sender := &fakeSender{}
+	server := startServer(t, sender, 1)
+
+	response, decoded := post(t, server, "secret", `{"recipient":"#room:example.org, @user:example.org","body":"hello","idempotency_key":"key"}`)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.StatusCode)
+	}
+	if len(decoded.Results) != 2 || decoded.Results[0].Recipient != "#room:example.org" || decoded.Results[1].Recipient != "@user:example.org" {
+		t.Fatalf("expected results for both recipients in order, got %+v", decoded.Results)
+	}
+	if decoded.Results[0].EventId != "$event" {
+		t.Fatalf("expected event $event, got %s", decoded.Results[0].EventId)
+	}
+	if len(sender.sent) != 1 || sender.sent[0] != "hello" {
+		t.Fatalf("expected the message to be sent once, got %v", sender.sent)
+	}
+	if sender.options.IdempotencyKey != "key" {
+		t.Fatalf("expected idempotency key key, got %s", sender.options.IdempotencyKey)
+	}
+}
+
+func TestSendAllRecipientsFailed(t *testing.T) {
+	server := startServer(t, &fakeSender{}, 1)
+
+	response, decoded := post(t, server, "secret", `{"recipient":"@broken:example.org","body":"hello"}`)
+	if response.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected status 502, got %d", response.StatusCode)
+	}
+	if len(decoded.Results) != 1 || decoded.Results[0].Error == nil {
+		t.Fatalf("expected a failed result, got %+v", decoded.Results)
+	}
+}
+
+func TestSendUnauthorized(t *testing.T) {
+	sender := &fakeSender{}
+	server := startServer(t, sender, 1)
+
+	for _, token := range []string{"", "wrong"} {
+		response, decoded := post(t, server, token, `{"recipient":"#room:example.org","body":"hello"}`)
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status 401, got %d", response.StatusCode)
+		}
+		if decoded.Error == nil || decoded.Error.Code != ErrorCodeUnauthorized {
+			t.Fatalf("expected unauthorized error, got %+v", decoded.Error)
+		}
+	}
+	if len(sender.sent) != 0 {
+		t.Fatalf("expected nothing to be sent, got %v", sender.sent)
+	}
+}
+
+func TestSendInvalidRequest(t *testing.T) {
+	server := startServer(t, &fakeSender{}, 1)
+
+	for _, body := range []string{`{`, `{"body":"hello"}`, `{"recipient":"#room:example.org","message_type":"m.image","body":"/tmp/file"}`} {
+		response, decoded := post(t, server, "secret", body)
+		if response.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", body, response.StatusCode)
+		}
+		if decoded.Error == nil {
+			t.Fatalf("expected an error for %s", body)
+		}
+	}
+}
+
+func TestSendQueueFull(t *testing.T) {
+	sender := &fakeSender{block: make(chan struct{}), started: make(chan struct{}, 1)}
+	server, relay := startRelay(t, sender, 1)
+	defer close(sender.block)
+
+	// the first request is being processed and the second one fills the queue
+	for i := range 2 {
+		go func() {
+			request, _ := http.NewRequest(http.MethodPost, server.URL+"/send", strings.NewReader(`{"recipient":"#room:example.org","body":"hello"}`))
+			request.Header.Set("Authorization", "Bearer secret")
+			response, err := server.Client().Do(request)
+			if err == nil {
+				_ = response.Body.Close()
+			}
+		}()
+		if i == 0 {
+			<-sender.started
+		}
+	}
+	for len(relay.queue) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	response, decoded := post(t, server, "secret", `{"recipient":"#room:example.org","body":"hello"}`)
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", response.StatusCode)
+	}
+	if decoded.Error == nil || decoded.Error.Code != ErrorCodeQueueFull || !decoded.Error.Retryable {
+		t.Fatalf("expected retryable queue_full error, got %+v", decoded.Error)
+	}
+	if response.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected a Retry-After header")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	sender := &fakeSender{health: matrix.SessionHealth{Syncing: true, CryptoReady: true}}
+	server := startServer(t, sender, 5)
+
+	response, err := server.Client().Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.StatusCode)
+	}
+
+	sender.health = matrix.SessionHealth{CryptoReady: true, KeyBackupVersion: "2", SyncErr: errors.New("sync failed")}
+	response, err = server.Client().Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", response.StatusCode)
+	}
+
+	var decoded HealthResponse
+	if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if decoded.Status != "unavailable" || decoded.Error != "sync failed" || decoded.QueueCapacity != 5 || decoded.KeyBackupVersion != "2" {
+		t.Fatalf("unexpected health response %+v", decoded)
+	}
+}
+
+func TestSendRequestTooLarge(t *testing.T) {
+	sender := &fakeSender{}
+	server := startServer(t, sender, 1)
+
+	body := `{"recipient":"#room:example.org","body":"` + strings.Repeat("a", MaxRequestSize) + `"}`
+	response, decoded := post(t, server, "secret", body)
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, got %d", response.StatusCode)
+	}
+	if decoded.Error == nil || decoded.Error.Code != ErrorCodeRequestTooLarge {
+		t.Fatalf("expected request_too_large error, got %+v", decoded.Error)
+	}
+	if len(sender.sent) != 0 {
+		t.Fatalf("expected nothing to be sent, got %v", sender.sent)
+	}
+}
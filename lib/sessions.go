@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"lib/matrix"
+	"sync"
+)
+
+var (
+	sessions      = make(map[uint64]*matrix.Session)
+	sessionsLock  sync.RWMutex
+	lastSessionId uint64
+)
+
+func storeSession(session *matrix.Session) uint64 {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	lastSessionId++
+	sessions[lastSessionId] = session
+
+	return lastSessionId
+}
+
+func findSession(handle uint64) (*matrix.Session, error) {
+	sessionsLock.RLock()
+	defer sessionsLock.RUnlock()
+
+	session, ok := sessions[handle]
+	if !ok {
+		return nil, fmt.Errorf("unknown session handle: %d", handle)
+	}
+
+	return session, nil
+}
+
+func removeSession(handle uint64) (*matrix.Session, error) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	session, ok := sessions[handle]
+	if !ok {
+		return nil, fmt.Errorf("unknown session handle: %d", handle)
+	}
+	delete(sessions, handle)
+
+	return session, nil
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+const VersionTableName = "notifier_version"
+
+var UpgradeTable dbutil.UpgradeTable
+
+func init() {
+	UpgradeTable.Register(-1, 1, 0, "Initial revision", dbutil.TxnModeOn, func(ctx context.Context, db *dbutil.Database) error {
+		_, err := db.Exec(ctx, `
+			CREATE TABLE notifier_device_bootstrap (
+				device_id  TEXT PRIMARY KEY,
+				master_key TEXT NOT NULL
+			)
+		`)
+		return err
+	})
+	UpgradeTable.Register(1, 2, 0, "Add sent transactions", dbutil.TxnModeOn, func(ctx context.Context, db *dbutil.Database) error {
+		_, err := db.Exec(ctx, `
+			CREATE TABLE notifier_sent_transaction (
+				device_id      TEXT NOT NULL,
+				transaction_id TEXT NOT NULL,
+				room_id        TEXT NOT NULL,
+				event_id       TEXT NOT NULL,
+				PRIMARY KEY (device_id, transaction_id, room_id)
+			)
+		`)
+		return err
+	})
+	UpgradeTable.Register(2, 3, 0, "Add sync state", dbutil.TxnModeOn, func(ctx context.Context, db *dbutil.Database) error {
+		_, err := db.Exec(ctx, `
+			CREATE TABLE notifier_sync_state (
+				user_id    TEXT NOT NULL,
+				device_id  TEXT NOT NULL,
+				filter_id  TEXT NOT NULL DEFAULT '',
+				next_batch TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (user_id, device_id)
+			)
+		`)
+		return err
+	})
+	UpgradeTable.Register(3, 4, 0, "Add the sync filter of the receiving sessions", dbutil.TxnModeOn, func(ctx context.Context, db *dbutil.Database) error {
+		_, err := db.Exec(ctx, "ALTER TABLE notifier_sync_state ADD COLUMN receive_filter_id TEXT NOT NULL DEFAULT ''")
+		return err
+	})
+}
+
+type NotifierStore struct {
+	*dbutil.Database
+}
+
+func NewNotifierStore(database *dbutil.Database) *NotifierStore {
+	return &NotifierStore{
+		Database: database.Child(VersionTableName, UpgradeTable, nil),
+	}
+}
+
+func (receiver *NotifierStore) GetBootstrappedMasterKey(ctx context.Context, deviceId id.DeviceID) (id.Ed25519, error) {
+	var masterKey id.Ed25519
+	err := receiver.QueryRow(ctx, "SELECT master_key FROM notifier_device_bootstrap WHERE device_id=$1", deviceId).Scan(&masterKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return masterKey, err
+}
+
+func (receiver *NotifierStore) SetBootstrappedMasterKey(ctx context.Context, deviceId id.DeviceID, masterKey id.Ed25519) error {
+	_, err := receiver.Exec(ctx, `
+		INSERT INTO notifier_device_bootstrap (device_id, master_key) VALUES ($1, $2)
+		ON CONFLICT (device_id) DO UPDATE SET master_key=excluded.master_key
+	`, deviceId, masterKey)
+
+	return err
+}
+
+func (receiver *NotifierStore) GetSentTransaction(
+	ctx context.Context,
+	deviceId id.DeviceID,
+	transactionId string,
+	roomId id.RoomID,
+) (id.EventID, error) {
+	var eventId id.EventID
+	err := receiver.QueryRow(
+		ctx,
+		"SELECT event_id FROM notifier_sent_transaction WHERE device_id=$1 AND transaction_id=$2 AND room_id=$3",
+		deviceId,
+		transactionId,
+		roomId,
+	).Scan(&eventId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return eventId, err
+}
+
+func (receiver *NotifierStore) SetSentTransaction(
+	ctx context.Context,
+	deviceId id.DeviceID,
+	transactionId string,
+	roomId id.RoomID,
+	eventId id.EventID,
+) error {
+	_, err := receiver.Exec(ctx, `
+		INSERT INTO notifier_sent_transaction (device_id, transaction_id, room_id, event_id) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (device_id, transaction_id, room_id) DO NOTHING
+	`, deviceId, transactionId, roomId, eventId)
+
+	return err
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"lib/db"
+	"path/filepath"
+	"testing"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func newTestStore(t *testing.T) *NotifierStore {
+	t.Helper()
+
+	database, err := (&db.SqliteProvider{}).Get(filepath.Join(t.TempDir(), "notifier.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	notifierStore := NewNotifierStore(database)
+	if err := notifierStore.Upgrade(context.Background()); err != nil {
+		t.Fatalf("failed to upgrade store: %v", err)
+	}
+
+	return notifierStore
+}
+
+func TestBootstrappedMasterKey(t *testing.T) {
+	notifierStore := newTestStore(t)
+	ctx := context.Background()
+
+	masterKey, err := notifierStore.GetBootstrappedMasterKey(ctx, "DEVICE")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if masterKey != "" {
+		t.Fatalf("expected empty master key, got %s", masterKey)
+	}
+
+	if err := notifierStore.SetBootstrappedMasterKey(ctx, "DEVICE", "first"); err != nil {
+		t.Fatalf("failed to set master key: %v", err)
+	}
+	if err := notifierStore.SetBootstrappedMasterKey(ctx, "DEVICE", "second"); err != nil {
+		t.Fatalf("failed to overwrite master key: %v", err)
+	}
+
+	masterKey, err = notifierStore.GetBootstrappedMasterKey(ctx, "DEVICE")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if masterKey != "second" {
+		t.Fatalf("expected master key second, got %s", masterKey)
+	}
+
+	masterKey, err = notifierStore.GetBootstrappedMasterKey(ctx, "OTHER")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if masterKey != "" {
+		t.Fatalf("expected empty master key for other device, got %s", masterKey)
+	}
+}
+
+func TestSentTransaction(t *testing.T) {
+	notifierStore := newTestStore(t)
+	ctx := context.Background()
+
+	eventId, err := notifierStore.GetSentTransaction(ctx, "DEVICE", "alert-1", "!room:example.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if eventId != "" {
+		t.Fatalf("expected no event ID, got %s", eventId)
+	}
+
+	if err := notifierStore.SetSentTransaction(ctx, "DEVICE", "alert-1", "!room:example.com", "$first"); err != nil {
+		t.Fatalf("failed to set transaction: %v", err)
+	}
+	if err := notifierStore.SetSentTransaction(ctx, "DEVICE", "alert-1", "!room:example.com", "$second"); err != nil {
+		t.Fatalf("failed to set transaction: %v", err)
+	}
+
+	eventId, err = notifierStore.GetSentTransaction(ctx, "DEVICE", "alert-1", "!room:example.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if eventId != "$first" {
+		t.Fatalf("expected the first event ID to be kept, got %s", eventId)
+	}
+
+	for _, lookup := range []struct {
+		deviceId string
+		roomId   string
+	}{{"OTHER", "!room:example.com"}, {"DEVICE", "!other:example.com"}} {
+		eventId, err = notifierStore.GetSentTransaction(ctx, id.DeviceID(lookup.deviceId), "alert-1", id.RoomID(lookup.roomId))
+		if err != nil || eventId != "" {
+			t.Fatalf("expected no event ID for %+v, got %s (%v)", lookup, eventId, err)
+		}
+	}
+}
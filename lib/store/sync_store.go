@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+type SyncStore struct {
+	store    *NotifierStore
+	deviceId id.DeviceID
+	// the sessions receiving the room messages use another filter, so both are kept
+	filterColumn string
+}
+
+var _ mautrix.SyncStore = (*SyncStore)(nil)
+
+func NewSyncStore(store *NotifierStore, deviceId id.DeviceID, receiveMessages bool) *SyncStore {
+	filterColumn := "filter_id"
+	if receiveMessages {
+		filterColumn = "receive_filter_id"
+	}
+
+	return &SyncStore{
+		store:        store,
+		deviceId:     deviceId,
+		filterColumn: filterColumn,
+	}
+}
+
+func (receiver *SyncStore) SaveFilterID(ctx context.Context, userId id.UserID, filterId string) error {
+	_, err := receiver.store.Exec(ctx, `
+		INSERT INTO notifier_sync_state (user_id, device_id, `+receiver.filterColumn+`) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET `+receiver.filterColumn+`=excluded.`+receiver.filterColumn+`
+	`, userId, receiver.deviceId, filterId)
+
+	return err
+}
+
+func (receiver *SyncStore) LoadFilterID(ctx context.Context, userId id.UserID) (string, error) {
+	return receiver.load(ctx, receiver.filterColumn, userId)
+}
+
+func (receiver *SyncStore) SaveNextBatch(ctx context.Context, userId id.UserID, nextBatchToken string) error {
+	_, err := receiver.store.Exec(ctx, `
+		INSERT INTO notifier_sync_state (user_id, device_id, next_batch) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET next_batch=excluded.next_batch
+	`, userId, receiver.deviceId, nextBatchToken)
+
+	return err
+}
+
+func (receiver *SyncStore) LoadNextBatch(ctx context.Context, userId id.UserID) (string, error) {
+	return receiver.load(ctx, "next_batch", userId)
+}
+
+func (receiver *SyncStore) load(ctx context.Context, column string, userId id.UserID) (string, error) {
+	var value string
+	err := receiver.store.QueryRow(
+		ctx,
+		"SELECT "+column+" FROM notifier_sync_state WHERE user_id=$1 AND device_id=$2",
+		userId,
+		receiver.deviceId,
+	).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return value, err
+}
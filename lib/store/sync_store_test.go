@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSyncStore(t *testing.T) {
+	notifierStore := newTestStore(t)
+	ctx := context.Background()
+
+	syncStore := NewSyncStore(notifierStore, "DEVICE", false)
+
+	filterId, err := syncStore.LoadFilterID(ctx, "@bot:example.org")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if filterId != "" {
+		t.Fatalf("expected empty filter ID, got %s", filterId)
+	}
+
+	if err := syncStore.SaveNextBatch(ctx, "@bot:example.org", "batch1"); err != nil {
+		t.Fatalf("failed to save next batch: %v", err)
+	}
+	if err := syncStore.SaveFilterID(ctx, "@bot:example.org", "filter"); err != nil {
+		t.Fatalf("failed to save filter ID: %v", err)
+	}
+	if err := syncStore.SaveNextBatch(ctx, "@bot:example.org", "batch2"); err != nil {
+		t.Fatalf("failed to overwrite next batch: %v", err)
+	}
+
+	filterId, err = syncStore.LoadFilterID(ctx, "@bot:example.org")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if filterId != "filter" {
+		t.Fatalf("expected filter ID filter, got %s", filterId)
+	}
+
+	nextBatch, err := syncStore.LoadNextBatch(ctx, "@bot:example.org")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if nextBatch != "batch2" {
+		t.Fatalf("expected next batch batch2, got %s", nextBatch)
+	}
+
+	nextBatch, err = NewSyncStore(notifierStore, "OTHER", false).LoadNextBatch(ctx, "@bot:example.org")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if nextBatch != "" {
+		t.Fatalf("expected empty next batch for another device, got %s", nextBatch)
+	}
+
+	filterId, err = NewSyncStore(notifierStore, "DEVICE", true).LoadFilterID(ctx, "@bot:example.org")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if filterId != "" {
+		t.Fatalf("expected separate filter ID for receiving sessions, got %s", filterId)
+	}
+}
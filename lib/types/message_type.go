@@ -5,4 +5,17 @@ type MessageType string
 const (
 	MessageTypeTextMessage MessageType = "m.text"
 	MessageTypeNotice      MessageType = "m.notice"
+	MessageTypeImage       MessageType = "m.image"
+	MessageTypeFile        MessageType = "m.file"
+	MessageTypeAudio       MessageType = "m.audio"
+	MessageTypeVideo       MessageType = "m.video"
 )
+
+func (messageType MessageType) IsAttachment() bool {
+	switch messageType {
+	case MessageTypeImage, MessageTypeFile, MessageTypeAudio, MessageTypeVideo:
+		return true
+	default:
+		return false
+	}
+}
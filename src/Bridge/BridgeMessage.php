@@ -22,6 +22,7 @@ final readonly class BridgeMessage
         #[SensitiveParameter] public string $pickleKey,
         public string $deviceId,
         public string $url,
+        public ?string $idempotencyKey = null,
     ) {
     }
 }
@@ -15,6 +15,7 @@ final readonly class GolangLibBridge
     public function __construct(
         ?string $libraryPath = null,
         ?string $headerPath = null,
+        private int $timeoutMs = 60_000,
     ) {
         $libDir = __DIR__ . '/../../lib/out';
 
@@ -36,37 +37,61 @@ final readonly class GolangLibBridge
         );
     }
 
-    public function send(BridgeMessage $bridgeMessage): string
+    /**
+     * @return array<string, string> the event IDs indexed by recipient
+     * @throws MatrixException if any recipient failed, the event IDs of the delivered recipients are kept in the exception
+     */
+    public function send(BridgeMessage $bridgeMessage): array
     {
-        try {
-            $err = $this->ffi->new('char*');
-            $result = $this->ffi->SendMessage(
-                $bridgeMessage->messageType->value,
-                $bridgeMessage->renderingType->value,
-                $bridgeMessage->message,
-                $bridgeMessage->recipient,
-                $bridgeMessage->databaseDsn,
-                $bridgeMessage->accessToken,
-                $bridgeMessage->recoveryKey,
-                $bridgeMessage->pickleKey,
-                $bridgeMessage->url,
-                $bridgeMessage->deviceId,
-                FFI::addr($err),
-            );
-
-            if (!FFI::isNull($err)) {
-                throw new MatrixException(FFI::string($err));
+        $response = $this->execute([
+            'operation' => 'send',
+            'timeout_ms' => $this->timeoutMs,
+            'credentials' => [
+                'database_dsn' => $bridgeMessage->databaseDsn,
+                'access_token' => $bridgeMessage->accessToken,
+                'recovery_key' => $bridgeMessage->recoveryKey,
+                'pickle_key' => $bridgeMessage->pickleKey,
+                'url' => $bridgeMessage->url,
+                'device_id' => $bridgeMessage->deviceId,
+            ],
+            'recipients' => array_values(array_filter(
+                array_map(trim(...), explode(',', $bridgeMessage->recipient)),
+                static fn (string $recipient): bool => $recipient !== '',
+            )),
+            'content' => [
+                'message_type' => $bridgeMessage->messageType->value,
+                'rendering_type' => $bridgeMessage->renderingType->value,
+                'message' => $bridgeMessage->message,
+            ],
+            'options' => [
+                'idempotency_key' => $bridgeMessage->idempotencyKey ?? '',
+            ],
+        ]);
+
+        $eventIds = [];
+        $errors = [];
+        $failedRecipients = [];
+        $firstError = null;
+        foreach ($response['results'] ?? [] as $result) {
+            if (isset($result['error'])) {
+                $errors[] = "{$result['recipient']}: {$result['error']['message']}";
+                $failedRecipients[] = $result['recipient'];
+                $firstError ??= $result['error'];
+            } else {
+                $eventIds[$result['recipient']] = $result['event_id'] ?? '';
             }
+        }
 
-            return FFI::string($result);
-        } finally {
-            if (isset($result)) {
-                FFI::free($result);
-            }
-            if (isset($err) && !FFI::isNull($err)) {
-                FFI::free($err);
-            }
+        if ($firstError !== null) {
+            throw MatrixException::fromErrorDetails(
+                $firstError,
+                'Failed sending to some recipients: ' . implode('; ', $errors),
+                $eventIds,
+                $failedRecipients,
+            );
         }
+
+        return $eventIds;
     }
 
     public function login(string $homeserver, string $username, string $password): LoginResponse
@@ -80,13 +105,14 @@ final readonly class GolangLibBridge
                 $homeserver,
                 $username,
                 $password,
+                $this->timeoutMs,
                 FFI::addr($err),
                 FFI::addr($deviceId),
                 FFI::addr($accessToken),
             );
 
             if (!FFI::isNull($err)) {
-                throw new MatrixException(FFI::string($err));
+                throw $this->createException($err);
             }
 
             return new LoginResponse(
@@ -94,15 +120,46 @@ final readonly class GolangLibBridge
                 deviceId: FFI::string($deviceId),
             );
         } finally {
-            if (!FFI::isNull($err)) {
-                FFI::free($err);
-            }
-            if (!FFI::isNull($accessToken)) {
-                FFI::free($accessToken);
-            }
-            if (!FFI::isNull($deviceId)) {
-                FFI::free($deviceId);
-            }
+            $this->ffi->FreeString($err);
+            $this->ffi->FreeString($accessToken);
+            $this->ffi->FreeString($deviceId);
+        }
+    }
+
+    /**
+     * @param array<string, mixed> $request
+     * @return array<string, mixed>
+     */
+    private function execute(array $request): array
+    {
+        $result = $this->ffi->Execute(json_encode(['version' => 1, ...$request], JSON_THROW_ON_ERROR));
+
+        try {
+            $response = json_decode(FFI::string($result), true, flags: JSON_THROW_ON_ERROR);
+        } finally {
+            $this->ffi->FreeString($result);
+        }
+
+        if (isset($response['error'])) {
+            throw MatrixException::fromErrorDetails($response['error']);
+        }
+
+        return $response;
+    }
+
+    private function createException(FFI\CData $err): MatrixException
+    {
+        $details = $this->ffi->ErrorDetails($err);
+        if (FFI::isNull($details)) {
+            return new MatrixException(FFI::string($err));
+        }
+
+        try {
+            return MatrixException::fromErrorDetails(
+                json_decode(FFI::string($details), true, flags: JSON_THROW_ON_ERROR),
+            );
+        } finally {
+            $this->ffi->FreeString($details);
         }
     }
 
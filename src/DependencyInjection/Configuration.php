@@ -17,7 +17,7 @@ final readonly class Configuration implements ConfigurationInterface
             ->addDefaultsIfNotSet()
             ->children()
                 ->stringNode('database_dsn')
-                    ->info('The DSN for a database used to store the bridge internal data. Currently supports only SQLite. If you provide only a filepath, SQLite is assumed.')
+                    ->info('The DSN for a database used to store the bridge internal data. Supports SQLite and PostgreSQL (postgres:// or postgresql://, optionally with a schema parameter). If you provide only a filepath, SQLite is assumed.')
                     ->defaultValue('%kernel.project_dir%/var/matrix_notifier/matrix_internal.sqlite3')
                 ->end()
                 ->stringNode('pickle_key')
@@ -39,6 +39,11 @@ final readonly class Configuration implements ConfigurationInterface
                     ->info('The default recipient when no recipient is set directly')
                     ->defaultNull()
                 ->end()
+                ->integerNode('timeout')
+                    ->info('The maximum time in milliseconds a single call to the Matrix bridge can take, including the initial sync. Set to 0 to disable the timeout.')
+                    ->min(0)
+                    ->defaultValue(60_000)
+                ->end()
                 ->arrayNode('lib')
                     ->addDefaultsIfNotSet()
                     ->info('You can customize the .so/.h library paths.')
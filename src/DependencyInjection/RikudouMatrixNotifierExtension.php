@@ -26,5 +26,6 @@ final class RikudouMatrixNotifierExtension extends Extension
         $container->setParameter('rikudou.internal.matrix.lib_path', $configuration['lib']['library_path'] ?? null);
         $container->setParameter('rikudou.internal.matrix.headers_path', $configuration['lib']['headers_path'] ?? null);
         $container->setParameter('rikudou.internal.matrix.default_recipient', $configuration['default_recipient'] ?? null);
+        $container->setParameter('rikudou.internal.matrix.timeout', $configuration['timeout']);
     }
 }
@@ -3,7 +3,48 @@
 namespace Rikudou\MatrixNotifier\Exception;
 
 use RuntimeException;
+use Throwable;
 
 final class MatrixException extends RuntimeException
 {
+    /**
+     * @param array<string, string> $eventIds the event IDs of the recipients the message was delivered to
+     * @param list<string> $failedRecipients
+     */
+    public function __construct(
+        string $message = '',
+        public readonly ?string $category = null,
+        public readonly ?string $matrixErrorCode = null,
+        public readonly ?int $httpStatus = null,
+        public readonly ?int $retryAfterMs = null,
+        public readonly bool $retryable = false,
+        public readonly array $eventIds = [],
+        public readonly array $failedRecipients = [],
+        ?Throwable $previous = null,
+    ) {
+        parent::__construct($message, 0, $previous);
+    }
+
+    /**
+     * @param array{code: string, message: string, errcode?: string, http_status?: int, retry_after_ms?: int, retryable?: bool} $error
+     * @param array<string, string> $eventIds
+     * @param list<string> $failedRecipients
+     */
+    public static function fromErrorDetails(
+        array $error,
+        ?string $message = null,
+        array $eventIds = [],
+        array $failedRecipients = [],
+    ): self {
+        return new self(
+            message: $message ?? $error['message'],
+            category: $error['code'],
+            matrixErrorCode: $error['errcode'] ?? null,
+            httpStatus: $error['http_status'] ?? null,
+            retryAfterMs: $error['retry_after_ms'] ?? null,
+            retryable: $error['retryable'] ?? false,
+            eventIds: $eventIds,
+            failedRecipients: $failedRecipients,
+        );
+    }
 }
@@ -12,6 +12,7 @@ final readonly class MatrixOptions implements MessageOptionsInterface
         public ?string $recipientId = null,
         public MessageType $messageType = MessageType::TextMessage,
         public RenderingType $renderingType = RenderingType::PlainText,
+        public ?string $idempotencyKey = null,
     ) {
     }
 
@@ -21,6 +22,7 @@ final readonly class MatrixOptions implements MessageOptionsInterface
             'recipientId' => $this->recipientId,
             'messageType' => $this->messageType->value,
             'renderingType' => $this->renderingType->value,
+            'idempotencyKey' => $this->idempotencyKey,
         ];
     }
 
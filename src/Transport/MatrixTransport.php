@@ -82,11 +82,26 @@ final class MatrixTransport extends AbstractTransport
             pickleKey: $this->pickleKey,
             deviceId: $this->deviceId,
             url: "https://{$this->getEndpoint()}",
+            idempotencyKey: $options->idempotencyKey,
         );
 
-        $result = $this->bridge->send($bridgeMessage);
+        try {
+            $result = $this->bridge->send($bridgeMessage);
+        } catch (MatrixException $exception) {
+            if ($exception->eventIds !== [] && $exception->failedRecipients !== []) {
+                // a retry of the message only goes to the recipients which failed
+                $message->options(new MatrixOptions(
+                    recipientId: implode(',', $exception->failedRecipients),
+                    messageType: $bridgeMessage->messageType,
+                    renderingType: $bridgeMessage->renderingType,
+                    idempotencyKey: $bridgeMessage->idempotencyKey,
+                ));
+            }
+
+            throw $exception;
+        }
         $sent = new SentMessage($message, (string) $this);
-        $sent->setMessageId($result);
+        $sent->setMessageId(implode(',', $result));
 
         return $sent;
     }
@@ -32,6 +32,7 @@ final class RikudouMatrixNotifierExtensionTest extends TestCase
         $this->assertNull($container->getParameter('rikudou.internal.matrix.lib_path'));
         $this->assertNull($container->getParameter('rikudou.internal.matrix.headers_path'));
         $this->assertNull($container->getParameter('rikudou.internal.matrix.default_recipient'));
+        $this->assertSame(60_000, $container->getParameter('rikudou.internal.matrix.timeout'));
     }
 
     public function testLoadRegistersCustomParameters(): void
@@ -48,6 +49,7 @@ final class RikudouMatrixNotifierExtensionTest extends TestCase
                 'recovery_key' => 'recovery',
                 'server_hostname' => 'matrix.example.com',
                 'default_recipient' => '@bot:example.com',
+                'timeout' => 5_000,
                 'lib' => [
                     'library_path' => '/opt/libmatrix.so',
                     'headers_path' => '/opt/libmatrix.h',
@@ -65,5 +67,6 @@ final class RikudouMatrixNotifierExtensionTest extends TestCase
         $this->assertSame('/opt/libmatrix.so', $container->getParameter('rikudou.internal.matrix.lib_path'));
         $this->assertSame('/opt/libmatrix.h', $container->getParameter('rikudou.internal.matrix.headers_path'));
         $this->assertSame('@bot:example.com', $container->getParameter('rikudou.internal.matrix.default_recipient'));
+        $this->assertSame(5_000, $container->getParameter('rikudou.internal.matrix.timeout'));
     }
 }
@@ -19,6 +19,7 @@ final class MatrixOptionsTest extends TestCase
             recipientId: '@john:example.com',
             messageType: MessageType::Notice,
             renderingType: RenderingType::Markdown,
+            idempotencyKey: 'alert-42',
         );
 
         $this->assertSame(
@@ -26,6 +27,7 @@ final class MatrixOptionsTest extends TestCase
                 'recipientId' => '@john:example.com',
                 'messageType' => MessageType::Notice->value,
                 'renderingType' => RenderingType::Markdown->value,
+                'idempotencyKey' => 'alert-42',
             ],
             $options->toArray(),
         );
@@ -44,6 +46,7 @@ final class MatrixOptionsTest extends TestCase
                 'recipientId' => null,
                 'messageType' => MessageType::TextMessage->value,
                 'renderingType' => RenderingType::PlainText->value,
+                'idempotencyKey' => null,
             ],
             $options->toArray(),
         );
@@ -41,10 +41,11 @@ final class MatrixTransportTest extends TestCase
                 $this->assertSame('pickle-key', $message->pickleKey);
                 $this->assertSame('DEVICEID', $message->deviceId);
                 $this->assertSame('https://matrix.example.com:8448', $message->url);
+                $this->assertSame('alert-42', $message->idempotencyKey);
 
                 return true;
             }))
-            ->willReturn('$123');
+            ->willReturn(['@john:example.com' => '$123']);
 
         $transport = new MatrixTransport(
             accessToken: 'access-token',
@@ -62,6 +63,7 @@ final class MatrixTransportTest extends TestCase
             recipientId: '@john:example.com',
             messageType: MessageType::Notice,
             renderingType: RenderingType::Html,
+            idempotencyKey: 'alert-42',
         ));
 
         $sentMessage = $transport->send($message);
@@ -81,7 +83,7 @@ final class MatrixTransportTest extends TestCase
 
                 return true;
             }))
-            ->willReturn('event-id');
+            ->willReturn(['@alice:example.com' => 'event-id']);
 
         $transport = new MatrixTransport(
             accessToken: 'access-token',
@@ -116,7 +118,7 @@ final class MatrixTransportTest extends TestCase
 
                 return true;
             }))
-            ->willReturn('event-id');
+            ->willReturn(['@bob:example.com' => 'event-id']);
 
         $transport = new MatrixTransport(
             accessToken: 'access-token',
@@ -137,6 +139,47 @@ final class MatrixTransportTest extends TestCase
         $this->assertSame('event-id', $sentMessage->getMessageId());
     }
 
+    public function testSendRetriesOnlyFailedRecipientsAfterPartialFailure(): void
+    {
+        $bridge = $this->createMock(GolangLibBridge::class);
+        $bridge->expects($this->once())
+            ->method('send')
+            ->willThrowException(new MatrixException(
+                message: 'Failed sending to some recipients: @bob:example.com: room not found',
+                category: 'room_not_found',
+                eventIds: ['@alice:example.com' => '$alice'],
+                failedRecipients: ['@bob:example.com'],
+            ));
+
+        $transport = new MatrixTransport(
+            accessToken: 'access-token',
+            recoveryKey: 'recovery-key',
+            pickleKey: 'pickle-key',
+            deviceId: 'DEVICEID',
+            databaseDsn: 'sqlite:///var/matrix.db',
+            bridge: $bridge,
+            defaultRecipient: '@default:example.com',
+        );
+
+        $message = new ChatMessage('Body', new MatrixOptions(
+            recipientId: '@alice:example.com,@bob:example.com',
+            messageType: MessageType::Notice,
+        ));
+
+        try {
+            $transport->send($message);
+            $this->fail('Expected the partial failure to be reported');
+        } catch (MatrixException $exception) {
+            $this->assertSame(['@alice:example.com' => '$alice'], $exception->eventIds);
+            $this->assertSame(['@bob:example.com'], $exception->failedRecipients);
+        }
+
+        $options = $message->getOptions();
+        $this->assertInstanceOf(MatrixOptions::class, $options);
+        $this->assertSame('@bob:example.com', $options->getRecipientId());
+        $this->assertSame(MessageType::Notice, $options->messageType);
+    }
+
     public function testSendThrowsOnUnsupportedMessageType(): void
     {
         $bridge = $this->createMock(GolangLibBridge::class);
@@ -175,7 +218,7 @@ final class MatrixTransportTest extends TestCase
 
                 return true;
             }))
-            ->willReturn('event-id');
+            ->willReturn(['@john:example.com' => 'event-id']);
 
         $transport = new MatrixTransport(
             accessToken: 'access-token',
@@ -206,7 +249,7 @@ final class MatrixTransportTest extends TestCase
 
                 return true;
             }))
-            ->willReturn('event-id');
+            ->willReturn(['@default:example.com' => 'event-id']);
 
         $transport = new MatrixTransport(
             accessToken: 'access-token',
@@ -235,7 +278,7 @@ final class MatrixTransportTest extends TestCase
 
                 return true;
             }))
-            ->willReturn('event-id');
+            ->willReturn(['@default:example.com' => 'event-id']);
 
         $transport = new MatrixTransport(
             accessToken: 'access-token',
@@ -276,7 +319,7 @@ final class MatrixTransportTest extends TestCase
 
                 return true;
             }))
-            ->willReturn('event-id');
+            ->willReturn(['@default:example.com' => 'event-id']);
 
         $transport = new MatrixTransport(
             accessToken: 'access-token',
@@ -305,7 +348,7 @@ final class MatrixTransportTest extends TestCase
 
                 return true;
             }))
-            ->willReturn('event-id');
+            ->willReturn(['@options:example.com' => 'event-id']);
 
         $transport = new MatrixTransport(
             accessToken: 'access-token',
@@ -338,7 +381,7 @@ final class MatrixTransportTest extends TestCase
 
                 return true;
             }))
-            ->willReturn('event-id');
+            ->willReturn(['@message:example.com' => 'event-id']);
 
         $transport = new MatrixTransport(
             accessToken: 'access-token',
@@ -396,7 +439,7 @@ final class MatrixTransportTest extends TestCase
 
                 return true;
             }))
-            ->willReturn('event-id');
+            ->willReturn(['@generic:example.com' => 'event-id']);
 
         $transport = new MatrixTransport(
             accessToken: 'access-token',